@@ -1,15 +1,23 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	_ "time/tzdata"
+	"unicode"
+	"unicode/utf8"
 
 	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -20,432 +28,3294 @@ type Trainer struct {
 	Bio          string   `json:"bio"`
 	Achievements []string `json:"achievements"`
 	Slots        []string `json:"slots"`
+	Blackouts    []string `json:"blackouts"`
+	Subscribers  []int64  `json:"subscribers"`
+	Schedule     SlotSpec `json:"schedule"`
+	MaxPerDay    int      `json:"max_per_day"`
+	Active       bool     `json:"active"`
+
+	// PriceModifier is the surcharge, in the gym currency, on top of the
+	// subscription for sessions with this trainer. Zero means no surcharge.
+	PriceModifier int `json:"price_modifier,omitempty"`
+
+	// SessionMinutes is the length of one session; zero means
+	// defaultSessionMinutes.
+	SessionMinutes int `json:"session_minutes,omitempty"`
+
+	// Away marks a trainer as temporarily unavailable: still listed, but
+	// not bookable.
+	Away bool `json:"away,omitempty"`
+
+	// PhotoURL is the trainer's headshot: an http(s) URL or a local file.
+	// PhotoFileID caches Telegram's file_id for it after the first upload.
+	PhotoURL    string `json:"photo_url,omitempty"`
+	PhotoFileID string `json:"photo_file_id,omitempty"`
+
+	// GroupClass marks a trainer who runs group classes rather than 1:1
+	// sessions.
+	GroupClass bool `json:"group_class,omitempty"`
+
+	// Specialties are short tags such as "бокс" or "ОФП", shown on the
+	// card and matched by /find.
+	Specialties []string `json:"specialties,omitempty"`
+}
+
+const defaultSessionMinutes = 60
+
+func (t Trainer) sessionLength() time.Duration {
+	if t.SessionMinutes <= 0 {
+		return defaultSessionMinutes * time.Minute
+	}
+	return time.Duration(t.SessionMinutes) * time.Minute
+}
+
+// slotRange renders a slot start as "08:00–09:00" using the trainer's
+// session length.
+func slotRange(t Trainer, clock string) string {
+	start, err := parseClock(clock)
+	if err != nil {
+		return clock
+	}
+	end := (start + int(t.sessionLength()/time.Minute)) % (24 * 60)
+	return clock + "–" + formatClock(end)
+}
+
+// UnmarshalJSON defaults Active to true so trainers saved before the flag
+// existed stay visible.
+func (t *Trainer) UnmarshalJSON(b []byte) error {
+	type plain Trainer
+	p := plain{Active: true}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*t = Trainer(p)
+	return nil
+}
+
+// bookable reports why new bookings with t are refused, if they are.
+func (t Trainer) bookable() error {
+	if !t.Active {
+		return errTrainerInactive
+	}
+	if t.Away {
+		return errTrainerAway
+	}
+	return nil
+}
+
+// bookableTrainers keeps the trainers that accept new bookings.
+func bookableTrainers(trainers []Trainer) []Trainer {
+	res := make([]Trainer, 0, len(trainers))
+	for _, t := range trainers {
+		if t.bookable() == nil {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// activeTrainers filters out soft-deleted trainers.
+func activeTrainers(trainers []Trainer) []Trainer {
+	res := make([]Trainer, 0, len(trainers))
+	for _, t := range trainers {
+		if t.Active {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// SlotSpec describes a trainer's working day: sessions start every Interval
+// minutes from Start up to (not including) End, skipping the Exclude ranges.
+type SlotSpec struct {
+	Start    string      `json:"start"`
+	End      string      `json:"end"`
+	Interval int         `json:"interval"`
+	Exclude  []TimeRange `json:"exclude"`
+}
+
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 type Booking struct {
+	ID       int    `json:"id"`
 	UserID   int64  `json:"user_id"`
 	Trainer  int    `json:"trainer"`
 	TimeSlot string `json:"time_slot"`
+	Date     string `json:"date"`
 	BookedAt int64  `json:"booked_at"`
+	Note     string `json:"note"`
+	// Code is the short reference shown at the front desk, e.g. "AF-7F3K".
+	Code string `json:"code"`
+
+	// CheckedIn is set by staff with /checkin; NoShow once the session
+	// ended without it. See noshow.go.
+	CheckedIn bool `json:"checked_in,omitempty"`
+	NoShow    bool `json:"no_show,omitempty"`
+
+	// RecurrenceID links the bookings of a weekly series; it is the ID of
+	// the booking the series was started from.
+	RecurrenceID int `json:"recurrence_id,omitempty"`
 }
 
 type User struct {
 	ID      int64  `json:"id"`
 	Name    string `json:"name"`
 	HasPaid bool   `json:"has_paid"`
+
+	Tier      string `json:"tier"`
+	PaidUntil int64  `json:"paid_until"`
+	// PaidAt is when the current subscription was bought, PaidPrice what
+	// was charged for it.
+	PaidAt    int64 `json:"paid_at,omitempty"`
+	PaidPrice int   `json:"paid_price,omitempty"`
+	// Discount is a percentage off the next payment, from a promo code.
+	Discount int `json:"discount,omitempty"`
+
+	Phone      string `json:"phone"`
+	PhoneAsked bool   `json:"phone_asked"`
+
+	// RemindedDays are the renewal reminders already sent for the
+	// subscription ending at RemindedFor.
+	RemindedFor  int64 `json:"reminded_for,omitempty"`
+	RemindedDays []int `json:"reminded_days,omitempty"`
+	Blocked      bool  `json:"blocked,omitempty"`
+
+	// RecentTrainers holds the trainers the user opened last, most recent
+	// first, at most maxRecentTrainers.
+	RecentTrainers []int `json:"recent_trainers,omitempty"`
+
+	// OnboardedAt is when the first-run introduction was shown.
+	OnboardedAt int64 `json:"onboarded_at,omitempty"`
+
+	// Lang is the user's interface language: "ru", "kk" or "en".
+	Lang string `json:"lang,omitempty"`
+
+	// ChatID is the user's private chat with the bot, used for messages the
+	// bot sends on its own. See chatIDFor.
+	ChatID int64 `json:"chat_id,omitempty"`
+
+	// Role is set with /setrole; empty means roleUser.
+	Role Role `json:"role,omitempty"`
+
+	// BookingLimitOverride replaces maxBookingsPerTrainer for this user when
+	// set by an admin with /setlimit.
+	BookingLimitOverride *int `json:"booking_limit_override,omitempty"`
+
+	// NoShowCount is the user's total no-shows; NoShowAt holds when each
+	// counted session ended, for the suspension window.
+	NoShowCount int     `json:"no_show_count,omitempty"`
+	NoShowAt    []int64 `json:"no_show_at,omitempty"`
+
+	// ReferralCode is the user's invite code, assigned on first use.
+	// ReferredBy is who invited them; ReferralCount is how many users they
+	// invited. BonusDays are reward days waiting for the next purchase.
+	ReferralCode  string `json:"referral_code,omitempty"`
+	ReferredBy    int64  `json:"referred_by,omitempty"`
+	ReferralCount int    `json:"referral_count,omitempty"`
+	BonusDays     int    `json:"bonus_days,omitempty"`
+}
+
+// IsActive reports whether the user's subscription is paid and not expired.
+// Subscriptions bought before expiry dates were tracked never expire.
+func (u User) IsActive() bool {
+	return u.HasPaid && (u.PaidUntil == 0 || now().Unix() < u.PaidUntil)
+}
+
+type Tier struct {
+	Code  string
+	Name  string
+	Price int
+	// DurationDays is how long one payment keeps the subscription active.
+	DurationDays int
+}
+
+var tiers = []Tier{
+	{Code: "gold", Name: "Gold", Price: 25000, DurationDays: 30},
+	{Code: "silver", Name: "Silver", Price: 18000, DurationDays: 30},
+	{Code: "bronze", Name: "Bronze", Price: 12000, DurationDays: 30},
+	{Code: "student", Name: "Студенческий", Price: 9000, DurationDays: 30},
+}
+
+// daysText renders n with the right Russian plural: "1 день", "3 дня",
+// "30 дней".
+func daysText(n int) string {
+	word := "дней"
+	switch {
+	case n%100 >= 11 && n%100 <= 14:
+	case n%10 == 1:
+		word = "день"
+	case n%10 >= 2 && n%10 <= 4:
+		word = "дня"
+	}
+	return fmt.Sprintf("%d %s", n, word)
+}
+
+func findTier(code string) (Tier, bool) {
+	for _, t := range tiers {
+		if t.Code == code {
+			return t, true
+		}
+	}
+	return Tier{}, false
 }
 
 type AppState struct {
+	// SchemaVersion is bumped by every entry in migrations.
+	SchemaVersion int `json:"schema_version"`
+
 	Users    map[int64]*User `json:"users"`
 	Trainers []Trainer       `json:"trainers"`
 	Bookings []Booking       `json:"bookings"`
+	Holds    []Hold          `json:"holds"`
+
+	// SlotsDate is the day Trainers[].Slots were generated for.
+	SlotsDate string `json:"slots_date"`
+
+	NextBookingID int `json:"next_booking_id"`
+	NextPaymentID int `json:"next_payment_id,omitempty"`
+	LastUpdateID  int `json:"last_update_id"`
+
+	// WelcomeImageFileID caches Telegram's file_id for WelcomeImageSource so
+	// the banner is uploaded only once.
+	WelcomeImageSource string `json:"welcome_image_source"`
+	WelcomeImageFileID string `json:"welcome_image_file_id"`
+
+	AuditLog []AdminAction `json:"audit_log"`
+
+	// Banned users get no service until unbanned.
+	Banned map[int64]bool `json:"banned,omitempty"`
+
+	Promos []PromoCode `json:"promos,omitempty"`
+
+	// Payments is the payment ledger, oldest first. See recordPayment.
+	Payments []Payment `json:"payments,omitempty"`
 }
 
+// stateMu guards state. Nothing that talks to Telegram (send, sendBatch,
+// answerCallback) may run while it is held: read what you need through
+// snapshot() or the accessor helpers, unlock, then do the network I/O.
 var (
 	state     AppState
 	stateMu   sync.Mutex
+	saveMu    sync.Mutex
 	statePath = filepath.Join(".", "state.json")
+	adminIDs  = map[int64]bool{}
+	staffChat int64
+	gymLoc    = time.UTC
 	gymName   = "Alfa Fitness"
-	priceText = "Прайсы абонементов (тенге):\n\n" +
-		"• Gold — 25 000 ₸ / мес\n" +
-		"• Silver — 18 000 ₸ / мес\n" +
-		"• Bronze — 12 000 ₸ / мес\n" +
-		"• Студенческий — 9 000 ₸ / мес\n\n" +
-		"Нажмите \"Оплатить\" для симуляции оплаты."
+	// currency is appended to every amount. Overridden by CURRENCY.
+	currency = "₸"
+
+	trainersPerPage = 5
+	welcomeImage    string
+	// slotsPerRow is the width of the time-slot grids. Three fits
+	// "08:00–09:00" labels on narrow phones.
+	slotsPerRow = 3
+	// maxActiveUsers caps how many users can hold an active subscription at
+	// once. Zero means no cap.
+	maxActiveUsers int
 )
 
-func defaultSlots() []string {
-	return []string{"08:00", "09:00", "10:00", "11:00", "12:00", "13:00", "14:00", "15:00", "16:00", "17:00", "18:00", "19:00", "20:00"}
+const dateLayout = "2006-01-02"
+
+// now is the bot's clock. Everything that depends on the current time reads
+// it through here so it can be replaced with a fake one.
+var now = time.Now
+
+func today() string {
+	return now().In(gymLoc).Format(dateLayout)
 }
 
-func defaultTrainers() []Trainer {
-	return []Trainer{
-		{ID: 1, Name: "Айдос Нуртаев", Bio: "Силовой тренинг, функциональная подготовка.", Achievements: []string{"МС по пауэрлифтингу", "Победитель Almaty Open 2022"}, Slots: defaultSlots()},
-		{ID: 2, Name: "Алия Жаксылыкова", Bio: "Фитнес для женщин, послеродовое восстановление.", Achievements: []string{"Сертифицированный персональный тренер NASM"}, Slots: defaultSlots()},
-		{ID: 3, Name: "Расул Абдрахман", Bio: "Бокс, ОФП, выносливость.", Achievements: []string{"Чемпион РК среди юниоров по боксу"}, Slots: defaultSlots()},
-		{ID: 4, Name: "Динара Есмухан", Bio: "Йога, гибкость, дыхательные практики.", Achievements: []string{"RYT-500 Yoga Alliance"}, Slots: defaultSlots()},
-		{ID: 5, Name: "Мади Бекен", Bio: "Кроссфит, снижение веса.", Achievements: []string{"Сертифицированный тренер CrossFit L1"}, Slots: defaultSlots()},
+// formatSlot renders a booking's date and time in the gym's timezone with an
+// explicit UTC offset, e.g. "15.10.2026 08:00 (UTC+05:00)".
+func formatSlot(date, clock string) string {
+	t, err := time.ParseInLocation(dateLayout+" 15:04", date+" "+clock, gymLoc)
+	if err != nil {
+		return strings.TrimSpace(date + " " + clock)
 	}
+	return t.Format("02.01.2006 15:04") + " (UTC" + t.Format("-07:00") + ")"
 }
 
-func loadState() error {
-	f, err := os.Open(statePath)
+// formatSession is formatSlot with the session's end time, e.g.
+// "15.10.2026 08:00–09:00 (UTC+05:00)".
+func formatSession(trainerID int, date, clock string) string {
+	tr, _ := getTrainerByID(trainerID)
+	if tr == nil {
+		return formatSlot(date, clock)
+	}
+	t, err := time.ParseInLocation(dateLayout+" 15:04", date+" "+clock, gymLoc)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			tmp := AppState{
-				Users:    map[int64]*User{},
-				Trainers: defaultTrainers(),
-				Bookings: []Booking{},
-			}
-			stateMu.Lock()
-			state = tmp
-			stateMu.Unlock()
-			return saveState()
-		}
-		return err
+		return formatSlot(date, clock)
 	}
-	defer f.Close()
+	return t.Format("02.01.2006") + " " + slotRange(*tr, clock) + " (UTC" + t.Format("-07:00") + ")"
+}
 
-	var tmp AppState
-	dec := json.NewDecoder(f)
-	if err := dec.Decode(&tmp); err != nil {
-		return err
+func loadConfig() {
+	loadAdmins()
+	welcomeImage = strings.TrimSpace(os.Getenv("WELCOME_IMAGE"))
+	if v := os.Getenv("HOLD_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			configProblem("HOLD_TTL: invalid duration %q", v)
+		} else {
+			holdTTL = d
+		}
 	}
-	if len(tmp.Trainers) == 0 {
-		tmp.Trainers = defaultTrainers()
+	if v := os.Getenv("OFFER_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			configProblem("OFFER_TTL: invalid duration %q", v)
+		} else {
+			offerTTL = d
+		}
 	}
-	if tmp.Users == nil {
-		tmp.Users = map[int64]*User{}
+	loadTrainersPerPage()
+	loadSlotsPerRow()
+	loadGymInfo()
+	loadRolloverConfig()
+	if v := os.Getenv("MAX_ACTIVE_USERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			configProblem("MAX_ACTIVE_USERS: expected a non-negative number, got %q", v)
+		} else {
+			maxActiveUsers = n
+		}
 	}
-
-	stateMu.Lock()
-	state = tmp
-	stateMu.Unlock()
-	return nil
-}
-
-func saveState() error {
-	stateMu.Lock()
-	defer stateMu.Unlock()
-
-	tmp := state
-	b, err := json.MarshalIndent(&tmp, "", "  ")
-	if err != nil {
-		return err
+	loadReminderDays()
+	loadReferralConfig()
+	loadNoShowConfig()
+	loadRecurringConfig()
+	loadHorizonConfig()
+	loadThrottleConfig()
+	loadInlineConfig()
+	invalidateMainMenus()
+	if v := strings.TrimSpace(os.Getenv("CURRENCY")); v != "" {
+		currency = v
 	}
-	return os.WriteFile(statePath, b, 0644)
+	tz := os.Getenv("GYM_TZ")
+	if tz == "" {
+		tz = "Asia/Almaty"
+	}
+	if loc, err := time.LoadLocation(tz); err != nil {
+		configProblem("GYM_TZ: unknown timezone %q", tz)
+	} else {
+		gymLoc = loc
+	}
+	if v := os.Getenv("STAFF_CHAT_ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			configProblem("STAFF_CHAT_ID: invalid value %q", v)
+		} else {
+			staffChat = id
+		}
+	}
+	loadDigestConfig()
 }
 
-func getOrCreateUser(id int64, name string) *User {
-	stateMu.Lock()
-	defer stateMu.Unlock()
-	u, ok := state.Users[id]
-	if !ok {
-		u = &User{ID: id, Name: name, HasPaid: false}
-		state.Users[id] = u
+func loadTrainersPerPage() {
+	v := os.Getenv("TRAINERS_PER_PAGE")
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > 20 {
+		configProblem("TRAINERS_PER_PAGE: expected 1..20, got %q", v)
+		return
 	}
-	return u
+	trainersPerPage = n
 }
 
-func getTrainerByID(id int) (*Trainer, int) {
-	stateMu.Lock()
-	defer stateMu.Unlock()
-	for i := range state.Trainers {
-		if state.Trainers[i].ID == id {
-			return &state.Trainers[i], i
-		}
+func loadSlotsPerRow() {
+	v := os.Getenv("SLOTS_PER_ROW")
+	if v == "" {
+		return
 	}
-	return nil, -1
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > 8 {
+		configProblem("SLOTS_PER_ROW: expected 1..8, got %q", v)
+		return
+	}
+	slotsPerRow = n
 }
 
-func bookSlot(userID int64, trainerID int, slot string) error {
-	stateMu.Lock()
-	defer stateMu.Unlock()
-
-	var existingTrainer int
-	userCountWithThisTrainer := 0
-	for _, b := range state.Bookings {
-		if b.UserID != userID {
+func loadAdmins() {
+	for _, f := range strings.Split(os.Getenv("ADMIN_IDS"), ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
 			continue
 		}
-		if existingTrainer == 0 {
-			existingTrainer = b.Trainer
-		}
-		if b.Trainer == trainerID {
-			userCountWithThisTrainer++
-		} else if trainerID != existingTrainer {
-			return fmt.Errorf("вы уже записаны к другому тренеру. Можно записываться только к одному тренеру.")
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			configProblem("ADMIN_IDS: invalid id %q", f)
+			continue
 		}
+		adminIDs[id] = true
 	}
-	if userCountWithThisTrainer >= 3 {
-		return fmt.Errorf("лимит: максимум 3 записи у одного тренера.")
+}
+
+func isAdmin(id int64) bool {
+	return hasRole(id, roleAdmin)
+}
+
+var lunchBreak = TimeRange{From: "13:00", To: "14:00"}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("неверное время %q", s)
 	}
+	return t.Hour()*60 + t.Minute(), nil
+}
 
-	idx := -1
-	for i := range state.Trainers {
-		if state.Trainers[i].ID == trainerID {
-			idx = i
-			break
-		}
+func formatClock(m int) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
+
+func (s SlotSpec) validate() error {
+	start, err := parseClock(s.Start)
+	if err != nil {
+		return err
 	}
-	if idx == -1 {
-		return fmt.Errorf("тренер не найден")
+	end, err := parseClock(s.End)
+	if err != nil {
+		return err
+	}
+	if start >= end {
+		return fmt.Errorf("начало %s не раньше конца %s", s.Start, s.End)
+	}
+	if s.Interval <= 0 {
+		return fmt.Errorf("интервал должен быть положительным")
 	}
 
-	pos := -1
-	for i, s := range state.Trainers[idx].Slots {
-		if s == slot {
-			pos = i
-			break
+	type span struct{ from, to int }
+	spans := make([]span, 0, len(s.Exclude))
+	for _, r := range s.Exclude {
+		from, err := parseClock(r.From)
+		if err != nil {
+			return err
+		}
+		to, err := parseClock(r.To)
+		if err != nil {
+			return err
 		}
+		if from >= to {
+			return fmt.Errorf("перерыв %s–%s задан наоборот", r.From, r.To)
+		}
+		spans = append(spans, span{from, to})
 	}
-	if pos == -1 {
-		return fmt.Errorf("слот уже занят или не существует")
+	sort.Slice(spans, func(i, j int) bool { return spans[i].from < spans[j].from })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].from < spans[i-1].to {
+			return fmt.Errorf("перерывы %s и %s пересекаются", formatClock(spans[i-1].from), formatClock(spans[i].from))
+		}
 	}
-
-	slots := state.Trainers[idx].Slots
-	state.Trainers[idx].Slots = append(slots[:pos], slots[pos+1:]...)
-
-	state.Bookings = append(state.Bookings, Booking{
-		UserID:   userID,
-		Trainer:  trainerID,
-		TimeSlot: slot,
-		BookedAt: time.Now().Unix(),
-	})
-
 	return nil
 }
 
-func mainMenuKeyboard() telegram.ReplyKeyboardMarkup {
-	return telegram.NewReplyKeyboard(
-		telegram.NewKeyboardButtonRow(
-			telegram.NewKeyboardButton("Тренеры"),
-			telegram.NewKeyboardButton("Прайс абонементов"),
-		),
-	)
-}
-
-func trainersInlineKeyboard(hasPaid bool) telegram.InlineKeyboardMarkup {
-	stateMu.Lock()
-	trainers := make([]Trainer, len(state.Trainers))
-	copy(trainers, state.Trainers)
-	stateMu.Unlock()
+// buildSlots lists the session start times described by spec. An invalid
+// spec yields no slots; use spec.validate() to find out why.
+func buildSlots(spec SlotSpec) []string {
+	if err := spec.validate(); err != nil {
+		return nil
+	}
+	start, _ := parseClock(spec.Start)
+	end, _ := parseClock(spec.End)
 
-	rows := [][]telegram.InlineKeyboardButton{}
-	for _, t := range trainers {
-		row := []telegram.InlineKeyboardButton{
-			telegram.NewInlineKeyboardButtonData("👤 "+t.Name, fmt.Sprintf("trainer_%d", t.ID)),
+	slots := []string{}
+	for m := start; m < end; m += spec.Interval {
+		excluded := false
+		for _, r := range spec.Exclude {
+			from, _ := parseClock(r.From)
+			to, _ := parseClock(r.To)
+			if m >= from && m < to {
+				excluded = true
+				break
+			}
 		}
-		if hasPaid {
-			row = append(row, telegram.NewInlineKeyboardButtonData("🗓 Запись", fmt.Sprintf("book_%d", t.ID)))
+		if !excluded {
+			slots = append(slots, formatClock(m))
 		}
-		rows = append(rows, row)
 	}
-	rows = append(rows, []telegram.InlineKeyboardButton{telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu")})
-	return telegram.NewInlineKeyboardMarkup(rows...)
+	return slots
 }
 
-func trainerDetailsKeyboard(t Trainer, hasPaid bool) telegram.InlineKeyboardMarkup {
-	row := []telegram.InlineKeyboardButton{}
-	if hasPaid {
-		row = append(row, telegram.NewInlineKeyboardButtonData("🗓 Запись", fmt.Sprintf("book_%d", t.ID)))
+func defaultTrainers() []Trainer {
+	trainers := []Trainer{
+		{ID: 1, Name: "Айдос Нуртаев", Bio: "Силовой тренинг, функциональная подготовка.", Achievements: []string{"МС по пауэрлифтингу", "Победитель Almaty Open 2022"},
+			Schedule: SlotSpec{Start: "08:00", End: "21:00", Interval: 60, Exclude: []TimeRange{lunchBreak}}},
+		{ID: 2, Name: "Алия Жаксылыкова", Bio: "Фитнес для женщин, послеродовое восстановление.", Achievements: []string{"Сертифицированный персональный тренер NASM"},
+			Schedule: SlotSpec{Start: "09:00", End: "18:00", Interval: 60, Exclude: []TimeRange{lunchBreak}}},
+		{ID: 3, Name: "Расул Абдрахман", Bio: "Бокс, ОФП, выносливость.", Achievements: []string{"Чемпион РК среди юниоров по боксу"},
+			Schedule: SlotSpec{Start: "10:00", End: "21:00", Interval: 90, Exclude: []TimeRange{lunchBreak}}},
+		{ID: 4, Name: "Динара Есмухан", Bio: "Йога, гибкость, дыхательные практики.", Achievements: []string{"RYT-500 Yoga Alliance"},
+			Schedule: SlotSpec{Start: "07:00", End: "21:00", Interval: 60, Exclude: []TimeRange{{From: "11:00", To: "17:00"}}}},
+		{ID: 5, Name: "Мади Бекен", Bio: "Кроссфит, снижение веса.", Achievements: []string{"Сертифицированный тренер CrossFit L1"},
+			Schedule: SlotSpec{Start: "08:00", End: "20:00", Interval: 60, Exclude: []TimeRange{lunchBreak}}},
 	}
-	row = append(row, telegram.NewInlineKeyboardButtonData("⬅️ Назад", "trainers"))
-	return telegram.NewInlineKeyboardMarkup(row)
+	for i := range trainers {
+		trainers[i].Slots = buildSlots(trainers[i].Schedule)
+		trainers[i].Active = true
+	}
+	return trainers
 }
 
-func scheduleKeyboard(trainerID int) telegram.InlineKeyboardMarkup {
+// initStatePath applies STATE_PATH, creates its directory and makes sure the
+// bot can write there, so a bad volume mount fails at startup rather than on
+// the first save.
+func initStatePath() error {
+	if v := strings.TrimSpace(os.Getenv("STATE_PATH")); v != "" {
+		statePath = v
+	}
+	if os.Getenv("STATE_IN_MEMORY") == "1" {
+		inMemory = true
+		log.Printf("!!! STATE_IN_MEMORY=1: state is kept in memory only, nothing is saved to %s and all changes are lost on restart", statePath)
+		return nil
+	}
+	dir := filepath.Dir(statePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("create %s: %w", dir, err)
+	}
+	if err := probeWritable(dir); err != nil {
+		return fmt.Errorf("%s is not writable (%w); mount it read-write, point STATE_PATH elsewhere, or set STATE_IN_MEMORY=1 to run without saving", dir, err)
+	}
+	return nil
+}
+
+// startFresh replaces the state with defaults and writes it to disk.
+func startFresh() error {
+	tmp := AppState{
+		SchemaVersion: currentSchemaVersion,
+		Users:         map[int64]*User{},
+		Trainers:      defaultTrainers(),
+		Bookings:      []Booking{},
+	}
 	stateMu.Lock()
-	slots := append([]string{}, state.Trainers[trainerID-1].Slots...)
+	state = tmp
 	stateMu.Unlock()
+	return saveState()
+}
 
-	rows := [][]telegram.InlineKeyboardButton{}
-	row := []telegram.InlineKeyboardButton{}
-	for i, s := range slots {
-		row = append(row, telegram.NewInlineKeyboardButtonData(s, fmt.Sprintf("slot_%d_%s", trainerID, s)))
-		if (i+1)%4 == 0 {
-			rows = append(rows, row)
-			row = []telegram.InlineKeyboardButton{}
+// loadState reads statePath. A corrupt file is moved aside to
+// state.json.bad.<timestamp> and the bot starts with defaults, unless
+// STRICT_STATE=1 is set, in which case the decode error is returned.
+func loadState() error {
+	f, err := os.Open(statePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return startFresh()
 		}
+		return err
 	}
-	if len(row) > 0 {
-		rows = append(rows, row)
+
+	var tmp AppState
+	err = json.NewDecoder(f).Decode(&tmp)
+	f.Close()
+	if err != nil {
+		if os.Getenv("STRICT_STATE") == "1" {
+			return fmt.Errorf("decode %s: %w", statePath, err)
+		}
+		backup := fmt.Sprintf("%s.bad.%d", statePath, now().Unix())
+		if rerr := os.Rename(statePath, backup); rerr != nil {
+			return fmt.Errorf("decode %s: %v; backup failed: %w", statePath, err, rerr)
+		}
+		log.Printf("!!! state file %s is corrupt (%v), moved to %s, starting with defaults", statePath, err, backup)
+		return startFresh()
+	}
+	if tmp, err = prepareState(tmp); err != nil {
+		return err
 	}
-	rows = append(rows, []telegram.InlineKeyboardButton{telegram.NewInlineKeyboardButtonData("⬅️ Назад", "trainers")})
-	return telegram.NewInlineKeyboardMarkup(rows...)
-}
 
-func pricingKeyboard() telegram.InlineKeyboardMarkup {
-	return telegram.NewInlineKeyboardMarkup(
-		telegram.NewInlineKeyboardRow(
-			telegram.NewInlineKeyboardButtonData("Оплатить Gold (25 000 ₸)", "pay_gold"),
-		),
-		telegram.NewInlineKeyboardRow(
-			telegram.NewInlineKeyboardButtonData("Оплатить Silver (18 000 ₸)", "pay_silver"),
-		),
-		telegram.NewInlineKeyboardRow(
-			telegram.NewInlineKeyboardButtonData("Оплатить Bronze (12 000 ₸)", "pay_bronze"),
-		),
-		telegram.NewInlineKeyboardRow(
-			telegram.NewInlineKeyboardButtonData("Оплатить Студенческий (9 000 ₸)", "pay_student"),
-		),
-		telegram.NewInlineKeyboardRow(
-			telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu"),
-		),
-	)
+	stateMu.Lock()
+	state = tmp
+	stateMu.Unlock()
+	return nil
 }
 
-func main() {
-	if err := loadState(); err != nil {
-		log.Fatalf("load state: %v", err)
+// prepareState fills in defaults and migrates a freshly decoded state.
+func prepareState(s AppState) (AppState, error) {
+	if len(s.Trainers) == 0 {
+		s.Trainers = defaultTrainers()
 	}
-
-	token := os.Getenv("TELEGRAM_TOKEN")
-	if token == "" {
-		log.Fatal("TELEGRAM_TOKEN is not set")
+	if s.Users == nil {
+		s.Users = map[int64]*User{}
 	}
+	return migrateState(s)
+}
+
+// reloadState replaces the in-memory state with the contents of statePath.
+// Unlike loadState it never falls back to defaults: on any error the
+// current state is kept. saveMu is held throughout so no save can write the
+// old state over the file while it is being read.
+func reloadState() (AppState, error) {
+	saveMu.Lock()
+	defer saveMu.Unlock()
 
-	bot, err := telegram.NewBotAPI(token)
+	f, err := os.Open(statePath)
 	if err != nil {
-		log.Panic(err)
+		return AppState{}, err
+	}
+	var tmp AppState
+	err = json.NewDecoder(f).Decode(&tmp)
+	f.Close()
+	if err != nil {
+		return AppState{}, fmt.Errorf("decode %s: %w", statePath, err)
+	}
+	if tmp, err = prepareState(tmp); err != nil {
+		return AppState{}, err
 	}
-	bot.Debug = false
-	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	u := telegram.NewUpdate(0)
-	u.Timeout = 60
-	updates := bot.GetUpdatesChan(u)
+	stateMu.Lock()
+	state = tmp
+	c := state.clone()
+	stateMu.Unlock()
+	return c, nil
+}
+
+func (t Trainer) clone() Trainer {
+	t.Achievements = append([]string(nil), t.Achievements...)
+	t.Specialties = append([]string(nil), t.Specialties...)
+	t.Slots = append([]string(nil), t.Slots...)
+	t.Blackouts = append([]string(nil), t.Blackouts...)
+	t.Subscribers = append([]int64(nil), t.Subscribers...)
+	t.Schedule.Exclude = append([]TimeRange(nil), t.Schedule.Exclude...)
+	return t
+}
+
+func (s AppState) clone() AppState {
+	c := AppState{
+		SchemaVersion: s.SchemaVersion,
+
+		Users:    make(map[int64]*User, len(s.Users)),
+		Trainers: make([]Trainer, len(s.Trainers)),
+		Bookings: make([]Booking, len(s.Bookings)),
+
+		SlotsDate:     s.SlotsDate,
+		NextBookingID: s.NextBookingID,
+		NextPaymentID: s.NextPaymentID,
+		LastUpdateID:  s.LastUpdateID,
+
+		WelcomeImageSource: s.WelcomeImageSource,
+		WelcomeImageFileID: s.WelcomeImageFileID,
+	}
+	copy(c.Bookings, s.Bookings)
+	c.Holds = make([]Hold, len(s.Holds))
+	for i, h := range s.Holds {
+		h.Queue = slices.Clone(h.Queue)
+		c.Holds[i] = h
+	}
+	c.AuditLog = append([]AdminAction(nil), s.AuditLog...)
+	c.Payments = append([]Payment(nil), s.Payments...)
+	c.Promos = make([]PromoCode, len(s.Promos))
+	for i, p := range s.Promos {
+		p.UsedBy = append([]int64(nil), p.UsedBy...)
+		c.Promos[i] = p
+	}
+	if s.Banned != nil {
+		c.Banned = make(map[int64]bool, len(s.Banned))
+		for id, v := range s.Banned {
+			c.Banned[id] = v
+		}
+	}
+	for id, u := range s.Users {
+		uc := *u
+		uc.RemindedDays = append([]int(nil), u.RemindedDays...)
+		uc.RecentTrainers = append([]int(nil), u.RecentTrainers...)
+		uc.NoShowAt = append([]int64(nil), u.NoShowAt...)
+		if u.BookingLimitOverride != nil {
+			n := *u.BookingLimitOverride
+			uc.BookingLimitOverride = &n
+		}
+		c.Users[id] = &uc
+	}
+	for i, t := range s.Trainers {
+		c.Trainers[i] = t.clone()
+	}
+	return c
+}
+
+// snapshot returns a deep copy of the state that callers may read freely
+// after the lock is released.
+func snapshot() AppState {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return state.clone()
+}
+
+func saveState() error {
+	saveMu.Lock()
+	defer saveMu.Unlock()
+
+	tmp := snapshot()
+	b, err := json.MarshalIndent(&tmp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeState(b)
+}
+
+// supportedLangs are the interface languages a user can have.
+var supportedLangs = []string{"ru", "kk", "en"}
+
+// langFromCode maps a Telegram language_code such as "en-US" to a supported
+// language, defaulting to Russian.
+func langFromCode(code string) string {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(code)), "-")
+	for _, l := range supportedLangs {
+		if base == l {
+			return l
+		}
+	}
+	return "ru"
+}
+
+// getOrCreateUser returns a copy of the user, creating them on first contact
+// with the language taken from their Telegram client. A private chat the
+// update came from is remembered as the user's ChatID. The copy is a view
+// as of this update; changes go through functions that take stateMu.
+func getOrCreateUser(id int64, name, langCode string, chat *telegram.Chat) User {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[id]
+	if !ok {
+		u = &User{ID: id, Name: name, HasPaid: false, Lang: langFromCode(langCode)}
+		state.Users[id] = u
+	}
+	// Any update from the user means the chat is reachable again.
+	u.Blocked = false
+	if chat != nil && chat.IsPrivate() {
+		u.ChatID = chat.ID
+	}
+	return *u
+}
+
+// chatIDFor returns the chat to message userID in. Users seen before ChatID
+// was recorded fall back to their user ID, which Telegram accepts as the
+// private chat ID.
+func chatIDFor(userID int64) int64 {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if u, ok := state.Users[userID]; ok && u.ChatID != 0 {
+		return u.ChatID
+	}
+	return userID
+}
+
+var (
+	errNoCapacity   = errors.New("запись временно закрыта, мест нет.")
+	errUserNotFound = errors.New("пользователь не найден")
+)
+
+// setUserPaid activates a subscription of the given tier for the tier's
+// DurationDays, plus any bonus days, starting now.
+func setUserPaid(userID int64, tierCode string) (User, error) {
+	tier, ok := findTier(tierCode)
+	if !ok {
+		return User{}, fmt.Errorf("неизвестный тариф %q", tierCode)
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	if maxActiveUsers > 0 && !u.IsActive() && activeUserCountLocked() >= maxActiveUsers {
+		return User{}, errNoCapacity
+	}
+	u.HasPaid = true
+	u.Tier = tier.Code
+	u.PaidAt = now().Unix()
+	u.PaidPrice = tier.Price
+	u.PaidUntil = now().AddDate(0, 0, tier.DurationDays+u.BonusDays).Unix()
+	u.BonusDays = 0
+	return *u, nil
+}
+
+func resetUserPaid(userID int64) (User, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok {
+		return User{}, errUserNotFound
+	}
+	u.HasPaid = false
+	u.Tier = ""
+	u.PaidUntil = 0
+	return *u, nil
+}
+
+func setUserPhone(userID int64, phone string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if u, ok := state.Users[userID]; ok {
+		u.Phone = phone
+		u.PhoneAsked = true
+	}
+}
+
+// markPhoneAsked records that the user was offered to share a phone number.
+// It reports whether the offer should be made now, i.e. it wasn't made before
+// and no number is on file.
+func markPhoneAsked(userID int64) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok || u.PhoneAsked || u.Phone != "" {
+		return false
+	}
+	u.PhoneAsked = true
+	return true
+}
+
+// addTrainer registers a new active trainer with the next free ID and
+// today's slots built from their schedule.
+func addTrainer(t Trainer) (Trainer, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return Trainer{}, fmt.Errorf("не указано имя тренера")
+	}
+	if err := t.Schedule.validate(); err != nil {
+		return Trainer{}, err
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	t.ID = 1
+	for _, other := range state.Trainers {
+		if other.ID >= t.ID {
+			t.ID = other.ID + 1
+		}
+	}
+	t.Active = true
+	t.Slots = buildSlots(t.Schedule)
+	t.Subscribers = nil
+	state.Trainers = append(state.Trainers, t)
+	return t.clone(), nil
+}
+
+// updateTrainer applies fn to the trainer under the state lock.
+func updateTrainer(id int, fn func(t *Trainer)) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for i := range state.Trainers {
+		if state.Trainers[i].ID == id {
+			fn(&state.Trainers[i])
+			return nil
+		}
+	}
+	return fmt.Errorf("тренер не найден")
+}
+
+const maxRecentTrainers = 3
+
+// touchRecentTrainer moves trainerID to the front of the user's recently
+// viewed list.
+func touchRecentTrainer(userID int64, trainerID int) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok {
+		return
+	}
+	recent := []int{trainerID}
+	for _, id := range u.RecentTrainers {
+		if id != trainerID && len(recent) < maxRecentTrainers {
+			recent = append(recent, id)
+		}
+	}
+	u.RecentTrainers = recent
+}
+
+// recentTrainers returns the user's recently viewed trainers that are still
+// active, most recent first.
+func recentTrainers(userID int64) []Trainer {
+	s := snapshot()
+	u, ok := s.Users[userID]
+	if !ok {
+		return nil
+	}
+	var res []Trainer
+	for _, id := range u.RecentTrainers {
+		for _, t := range s.Trainers {
+			if t.ID == id && t.Active {
+				res = append(res, t)
+			}
+		}
+	}
+	return res
+}
+
+func getTrainerByID(id int) (*Trainer, int) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for i := range state.Trainers {
+		if state.Trainers[i].ID == id {
+			t := state.Trainers[i].clone()
+			return &t, i
+		}
+	}
+	return nil, -1
+}
+
+func bookSlot(userID int64, trainerID int, slot string) (Booking, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return bookSlotLocked(userID, trainerID, slot)
+}
+
+// maxBookingsPerTrainer is how many sessions a user may hold with one
+// trainer unless an admin overrides it for them.
+const maxBookingsPerTrainer = 3
+
+// bookingLimit is the user's per-trainer cap.
+func (u User) bookingLimit() int {
+	if u.BookingLimitOverride != nil {
+		return *u.BookingLimitOverride
+	}
+	return maxBookingsPerTrainer
+}
+
+// checkUserLimits enforces the single-trainer rule and the per-trainer cap.
+// Callers must hold stateMu.
+func checkUserLimits(userID int64, trainerID int) error {
+	if state.Banned[userID] {
+		return fmt.Errorf("вы заблокированы")
+	}
+	if err := checkNoShowSuspension(userID); err != nil {
+		return err
+	}
+	var existingTrainer int
+	userCountWithThisTrainer := 0
+	date := today()
+	for _, b := range state.Bookings {
+		// Past sessions are kept for history and don't count.
+		if b.UserID != userID || b.Date < date {
+			continue
+		}
+		if existingTrainer == 0 {
+			existingTrainer = b.Trainer
+		}
+		if b.Trainer == trainerID {
+			userCountWithThisTrainer++
+		} else if trainerID != existingTrainer {
+			return fmt.Errorf("вы уже записаны к другому тренеру. Можно записываться только к одному тренеру.")
+		}
+	}
+	limit := maxBookingsPerTrainer
+	if u, ok := state.Users[userID]; ok {
+		limit = u.bookingLimit()
+	}
+	if userCountWithThisTrainer >= limit {
+		return fmt.Errorf("лимит: максимум %d записи у одного тренера.", limit)
+	}
+	return nil
+}
+
+var (
+	errTrainerInactive = errors.New("тренер больше не принимает записи.")
+	errTrainerAway     = errors.New("тренер временно недоступен.")
+	errSlotTaken       = errors.New("слот уже занят или не существует")
+	errSlotOverlap     = errors.New("у вас уже есть тренировка в это время")
+)
+
+// checkUserOverlap rejects a second session for the user at the same date
+// and time, whichever trainer it is with. The booking with ID except, one
+// being moved, is left out; pass 0 for a new booking. Callers must hold
+// stateMu.
+func checkUserOverlap(userID int64, date, slot string, except int) error {
+	for _, b := range state.Bookings {
+		if b.UserID == userID && b.Date == date && b.TimeSlot == slot && b.ID != except {
+			return errSlotOverlap
+		}
+	}
+	return nil
+}
+
+// checkTrainerDailyCap rejects a booking once the trainer has MaxPerDay
+// sessions (bookings plus pending holds) on date. Zero means no cap. The
+// booking with ID except, one being moved, is not counted. Callers must hold
+// stateMu.
+func checkTrainerDailyCap(t Trainer, date string, except int) error {
+	if t.MaxPerDay <= 0 {
+		return nil
+	}
+	n := 0
+	for _, b := range state.Bookings {
+		if b.Trainer == t.ID && b.Date == date && b.ID != except {
+			n++
+		}
+	}
+	for _, h := range state.Holds {
+		if h.Trainer == t.ID && h.Date == date {
+			n++
+		}
+	}
+	if n >= t.MaxPerDay {
+		return fmt.Errorf("тренер полностью занят на этот день.")
+	}
+	return nil
+}
+
+// checkSlotRulesLocked runs the checks a session with t at slot on date
+// must pass before it is booked, held or moved to: the trainer takes
+// bookings, the date is open and not a day off, the daily cap has room and
+// the user has nothing else at that time. except is the ID of a booking being
+// moved, which counts neither towards the cap nor as an overlap; 0 for a new
+// session. Whether the slot itself is free is left to the caller. Callers
+// must hold stateMu.
+func checkSlotRulesLocked(userID int64, t Trainer, date, slot string, except int) error {
+	if err := t.bookable(); err != nil {
+		return err
+	}
+	if err := checkBookingHorizon(date); err != nil {
+		return err
+	}
+	if isBlackout(t, date) {
+		return fmt.Errorf("тренер не работает в этот день.")
+	}
+	if err := checkTrainerDailyCap(t, date, except); err != nil {
+		return err
+	}
+	return checkUserOverlap(userID, date, slot, except)
+}
+
+// bookSlotLocked is bookSlot for callers that already hold stateMu.
+func bookSlotLocked(userID int64, trainerID int, slot string) (Booking, error) {
+	ensureTodayLocked()
+	if err := checkUserLimits(userID, trainerID); err != nil {
+		return Booking{}, err
+	}
+
+	idx := -1
+	for i := range state.Trainers {
+		if state.Trainers[i].ID == trainerID {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return Booking{}, fmt.Errorf("тренер не найден")
+	}
+	date := today()
+	if err := checkSlotRulesLocked(userID, state.Trainers[idx], date, slot, 0); err != nil {
+		return Booking{}, err
+	}
+
+	pos := -1
+	for i, s := range state.Trainers[idx].Slots {
+		if s == slot {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return Booking{}, errSlotTaken
+	}
+
+	slots := state.Trainers[idx].Slots
+	state.Trainers[idx].Slots = append(slots[:pos], slots[pos+1:]...)
+
+	state.NextBookingID++
+	b := Booking{
+		ID:       state.NextBookingID,
+		UserID:   userID,
+		Trainer:  trainerID,
+		TimeSlot: slot,
+		Date:     date,
+		BookedAt: now().Unix(),
+		Code:     newBookingCode(state.Bookings),
+	}
+	state.Bookings = append(state.Bookings, b)
+
+	return b, nil
+}
+
+// bookingCodeAlphabet leaves out 0/O and 1/I so codes survive being read
+// aloud at the front desk.
+const bookingCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+// newBookingCode returns a code like "AF-7F3K" not used by any of bookings.
+func newBookingCode(bookings []Booking) string {
+	taken := make(map[string]bool, len(bookings))
+	for _, b := range bookings {
+		taken[b.Code] = true
+	}
+	buf := make([]byte, 4)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		for i := range buf {
+			buf[i] = bookingCodeAlphabet[int(buf[i])%len(bookingCodeAlphabet)]
+		}
+		code := "AF-" + string(buf)
+		if !taken[code] {
+			return code
+		}
+	}
+}
+
+const maxNoteLen = 300
+
+// sanitizeNote strips control characters (newlines excepted) and caps the
+// note at maxNoteLen characters.
+func sanitizeNote(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if r := []rune(s); len(r) > maxNoteLen {
+		s = string(r[:maxNoteLen])
+	}
+	return s
+}
+
+func setBookingNote(userID int64, bookingID int, note string) (Booking, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	pos := findUserBooking(userID, bookingID)
+	if pos == -1 {
+		return Booking{}, fmt.Errorf("запись не найдена")
+	}
+	state.Bookings[pos].Note = note
+	return state.Bookings[pos], nil
+}
+
+// cancelBooking removes the user's booking, today's or a later one, and puts
+// the slot back on sale. Along with the removed booking it returns the users
+// who asked to be notified about free slots of this trainer; the
+// subscription list is cleared once they have been handed out. Later days
+// have no slot list yet, so cancelling there notifies no one.
+func cancelBooking(userID int64, bookingID int) (Booking, []int64, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return cancelBookingLocked(userID, bookingID)
+}
+
+// cancelBookingLocked is cancelBooking for callers that already hold stateMu.
+func cancelBookingLocked(userID int64, bookingID int) (Booking, []int64, error) {
+	date := today()
+	pos := -1
+	for i, b := range state.Bookings {
+		if b.ID == bookingID && b.UserID == userID && b.Date >= date {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return Booking{}, nil, fmt.Errorf("запись не найдена")
+	}
+	b := state.Bookings[pos]
+	state.Bookings = append(state.Bookings[:pos], state.Bookings[pos+1:]...)
+	if b.Date != date {
+		return b, nil, nil
+	}
+	return b, releaseSlot(userID, b.Trainer, b.TimeSlot), nil
+}
+
+// userBookingByCode finds the user's booking by its reference code. The
+// "AF-" prefix and letter case are optional.
+func userBookingByCode(userID int64, code string) (Booking, bool) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !strings.HasPrefix(code, "AF-") {
+		code = "AF-" + code
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for _, b := range state.Bookings {
+		if b.UserID == userID && b.Code == code {
+			return b, true
+		}
+	}
+	return Booking{}, false
+}
+
+// handleCancel is the text-command counterpart of the "Отменить" button:
+// /cancel <code>. Without a code it shows the booking picker.
+func handleCancel(bot Sender, msg *telegram.Message) {
+	code := strings.TrimSpace(msg.CommandArguments())
+	if code == "" {
+		_ = send(bot, cancelPickerMessage(msg.Chat.ID, msg.From.ID, ""))
+		return
+	}
+	found, ok := userBookingByCode(msg.From.ID, code)
+	if !ok {
+		_ = replyError(bot, msg.Chat.ID, "Запись не найдена.")
+		return
+	}
+	b, notify, err := cancelBooking(msg.From.ID, found.ID)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось отменить: "+err.Error())
+		return
+	}
+	_ = saveState()
+	m := telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Запись %s на %s отменена.", b.Code, formatSessionFor(b.UserID, b.Trainer, b.Date, b.TimeSlot)))
+	m.ReplyMarkup = mainMenuKeyboard(userLang(msg.From.ID))
+	_ = send(bot, m)
+	notifySubscribers(bot, notify, b.Trainer, b.TimeSlot)
+}
+
+// cancelPickerMessage lists the user's upcoming bookings as buttons that lead
+// to a cancel confirmation. Buttons carry the booking code, which stays valid
+// however the bookings list changes. note, if set, is shown above the list.
+func cancelPickerMessage(chatID, userID int64, note string) telegram.MessageConfig {
+	bookings := upcomingUserBookings(userID)
+	if len(bookings) == 0 {
+		m := telegram.NewMessage(chatID, strings.TrimSpace(note+"\n\nУ вас нет предстоящих записей."))
+		m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu")))
+		return m
+	}
+	rows := make([][]telegram.InlineKeyboardButton, 0, len(bookings)+1)
+	for _, b := range bookings {
+		rows = append(rows, telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData(
+			"❌ "+formatSessionFor(userID, b.Trainer, b.Date, b.TimeSlot), "ccancel_"+b.Code)))
+	}
+	rows = append(rows, telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu")))
+	m := telegram.NewMessage(chatID, strings.TrimSpace(note+"\n\nВыберите запись, которую хотите отменить:"))
+	m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(rows...)
+	return m
+}
+
+// moveBooking reschedules the user's booking to another free slot of the same
+// trainer. It returns the booking as it was before the move and, like
+// cancelBooking, the subscribers to notify about the slot that was given up.
+func moveBooking(userID int64, bookingID int, slot string) (Booking, []int64, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	ensureTodayLocked()
+
+	pos := findUserBooking(userID, bookingID)
+	if pos == -1 {
+		return Booking{}, nil, fmt.Errorf("запись не найдена")
+	}
+	b := state.Bookings[pos]
+	if b.TimeSlot == slot {
+		return b, nil, nil
+	}
+
+	idx := -1
+	for i := range state.Trainers {
+		if state.Trainers[i].ID == b.Trainer {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return Booking{}, nil, fmt.Errorf("тренер не найден")
+	}
+	if err := checkSlotRulesLocked(userID, state.Trainers[idx], b.Date, slot, b.ID); err != nil {
+		return Booking{}, nil, err
+	}
+	free := -1
+	for i, s := range state.Trainers[idx].Slots {
+		if s == slot {
+			free = i
+			break
+		}
+	}
+	if free == -1 {
+		return Booking{}, nil, errSlotTaken
+	}
+	slots := state.Trainers[idx].Slots
+	state.Trainers[idx].Slots = append(slots[:free], slots[free+1:]...)
+
+	state.Bookings[pos].TimeSlot = slot
+	state.Bookings[pos].BookedAt = now().Unix()
+	return b, releaseSlot(userID, b.Trainer, b.TimeSlot), nil
+}
+
+// findUserBooking returns the index of the user's booking for today, or -1.
+// Callers must hold stateMu.
+func findUserBooking(userID int64, bookingID int) int {
+	date := today()
+	for i, b := range state.Bookings {
+		if b.ID == bookingID && b.UserID == userID && b.Date == date {
+			return i
+		}
+	}
+	return -1
+}
+
+// releaseSlot puts slot back on sale and hands out the trainer's subscribers,
+// except userID who freed it. Callers must hold stateMu.
+func releaseSlot(userID int64, trainerID int, slot string) []int64 {
+	for i := range state.Trainers {
+		if state.Trainers[i].ID != trainerID {
+			continue
+		}
+		state.Trainers[i].Slots = insertSlot(state.Trainers[i].Slots, slot)
+		var notify []int64
+		for _, id := range state.Trainers[i].Subscribers {
+			if id != userID {
+				notify = append(notify, id)
+			}
+		}
+		state.Trainers[i].Subscribers = nil
+		return notify
+	}
+	return nil
+}
+
+func subscribeToTrainer(userID int64, trainerID int) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for i := range state.Trainers {
+		if state.Trainers[i].ID != trainerID {
+			continue
+		}
+		for _, id := range state.Trainers[i].Subscribers {
+			if id == userID {
+				return nil
+			}
+		}
+		state.Trainers[i].Subscribers = append(state.Trainers[i].Subscribers, userID)
+		return nil
+	}
+	return fmt.Errorf("тренер не найден")
+}
+
+func userBookings(userID int64) []Booking {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	date := today()
+	var res []Booking
+	for _, b := range state.Bookings {
+		if b.UserID == userID && b.Date == date {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// earliestAvailable finds the soonest slot later today than now across all
+// trainers.
+func earliestAvailable(now time.Time) (trainerID int, slot string, ok bool) {
+	return earliestSlot(bookableTrainers(snapshot().Trainers), now)
+}
+
+// earliestAvailableFor is earliestAvailable restricted to the trainer the
+// user has upcoming bookings with, since they can't book anyone else. Past
+// bookings are kept for history and don't restrict anything.
+func earliestAvailableFor(userID int64, now time.Time) (int, string, bool) {
+	s := snapshot()
+	date := now.In(gymLoc).Format(dateLayout)
+	for _, b := range s.Bookings {
+		if b.UserID != userID || b.Date < date {
+			continue
+		}
+		for _, t := range s.Trainers {
+			if t.ID == b.Trainer {
+				return earliestSlot(bookableTrainers([]Trainer{t}), now)
+			}
+		}
+	}
+	return earliestSlot(bookableTrainers(s.Trainers), now)
+}
+
+func earliestSlot(trainers []Trainer, now time.Time) (trainerID int, slot string, ok bool) {
+	now = now.In(gymLoc)
+	date := now.Format(dateLayout)
+	current := now.Format("15:04")
+	for _, t := range trainers {
+		if isBlackout(t, date) {
+			continue
+		}
+		for _, s := range t.Slots {
+			if s <= current {
+				continue
+			}
+			if !ok || s < slot {
+				trainerID, slot, ok = t.ID, s, true
+			}
+			break
+		}
+	}
+	return trainerID, slot, ok
+}
+
+// slotSuggestions is how many alternatives are offered when the chosen
+// slot was taken.
+const slotSuggestions = 3
+
+// nearestSlots returns up to n of the trainer's free slots closest in time to
+// around, nearest first; of two equally close slots the earlier one wins.
+// around itself and slots that have already started are left out. The result
+// is empty when the trainer has nothing left today.
+func nearestSlots(trainerID int, around string, n int) []string {
+	tr, _ := getTrainerByID(trainerID)
+	if tr == nil || isBlackout(*tr, today()) {
+		return nil
+	}
+	return closestSlots(tr.Slots, around, now().In(gymLoc).Format("15:04"), n)
+}
+
+// closestSlots is the ordering behind nearestSlots: slots after current,
+// other than around, by distance from around.
+func closestSlots(slots []string, around, current string, n int) []string {
+	target, err := parseClock(around)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, s := range slots {
+		if s != around && s > current {
+			out = append(out, s)
+		}
+	}
+	dist := func(s string) int {
+		m, _ := parseClock(s)
+		if m < target {
+			return target - m
+		}
+		return m - target
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		di, dj := dist(out[i]), dist(out[j])
+		if di != dj {
+			return di < dj
+		}
+		return out[i] < out[j]
+	})
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
+
+func isBlackout(t Trainer, date string) bool {
+	for _, d := range t.Blackouts {
+		if d == date {
+			return true
+		}
+	}
+	return false
+}
+
+func insertSlot(slots []string, slot string) []string {
+	i := sort.SearchStrings(slots, slot)
+	if i < len(slots) && slots[i] == slot {
+		return slots
+	}
+	slots = append(slots, "")
+	copy(slots[i+1:], slots[i:])
+	slots[i] = slot
+	return slots
+}
+
+// addBlackout marks date as a day off for the trainer and drops the bookings
+// made for that date. The dropped bookings are returned so their owners can
+// be notified.
+func addBlackout(trainerID int, date string) ([]Booking, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	idx := -1
+	for i := range state.Trainers {
+		if state.Trainers[i].ID == trainerID {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return nil, fmt.Errorf("тренер не найден")
+	}
+	if !isBlackout(state.Trainers[idx], date) {
+		state.Trainers[idx].Blackouts = append(state.Trainers[idx].Blackouts, date)
+	}
+
+	var dropped []Booking
+	kept := state.Bookings[:0]
+	for _, b := range state.Bookings {
+		if b.Trainer == trainerID && b.Date == date {
+			dropped = append(dropped, b)
+			// Slots is today's schedule; a future booking's time may be
+			// booked by someone else today.
+			if b.Date == today() {
+				state.Trainers[idx].Slots = insertSlot(state.Trainers[idx].Slots, b.TimeSlot)
+			}
+			continue
+		}
+		kept = append(kept, b)
+	}
+	state.Bookings = kept
+	return dropped, nil
+}
+
+// mainMenuLayout is the main reply keyboard, row by row.
+var mainMenuLayout = [][]menuAction{
+	{actionTrainers, actionPrices},
+	{actionMyBookings, actionCancel},
+	{actionEarliest, actionRecent},
+	{actionPay, actionSupport},
+}
+
+// mainMenus caches the main keyboard per supported language, since it goes
+// out with almost every reply. The keyboard is the same for every role, so
+// lang is the whole key; anything that changes the labels or the layout must
+// call invalidateMainMenus.
+var (
+	mainMenus   = map[string]telegram.ReplyKeyboardMarkup{}
+	mainMenusMu sync.Mutex
+)
+
+// mainMenuKeyboard is the main reply keyboard labelled in lang. The result
+// is shared between callers and must not be modified.
+func mainMenuKeyboard(lang string) telegram.ReplyKeyboardMarkup {
+	if !slices.Contains(supportedLangs, lang) {
+		return buildMainMenu(lang)
+	}
+	mainMenusMu.Lock()
+	defer mainMenusMu.Unlock()
+	kb, ok := mainMenus[lang]
+	if !ok {
+		kb = buildMainMenu(lang)
+		mainMenus[lang] = kb
+	}
+	return kb
+}
+
+// invalidateMainMenus drops the cached keyboards so the next reply is built
+// from the current labels and layout.
+func invalidateMainMenus() {
+	mainMenusMu.Lock()
+	defer mainMenusMu.Unlock()
+	clear(mainMenus)
+}
+
+func buildMainMenu(lang string) telegram.ReplyKeyboardMarkup {
+	rows := make([][]telegram.KeyboardButton, len(mainMenuLayout))
+	for i, actions := range mainMenuLayout {
+		for _, a := range actions {
+			rows[i] = append(rows[i], telegram.NewKeyboardButton(menuLabel(lang, a)))
+		}
+	}
+	return telegram.NewReplyKeyboard(rows...)
+}
+
+func recentTrainersKeyboard(trainers []Trainer) telegram.InlineKeyboardMarkup {
+	rows := [][]telegram.InlineKeyboardButton{}
+	for _, t := range trainers {
+		rows = append(rows, telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData(trainerIcon(t)+t.Name, fmt.Sprintf("trainer_%d", t.ID)),
+		))
+	}
+	return telegram.NewInlineKeyboardMarkup(rows...)
+}
+
+func phoneRequestKeyboard(lang string) telegram.ReplyKeyboardMarkup {
+	kb := telegram.NewReplyKeyboard(
+		telegram.NewKeyboardButtonRow(telegram.NewKeyboardButtonContact("📱 Поделиться номером")),
+		telegram.NewKeyboardButtonRow(telegram.NewKeyboardButton(menuLabel(lang, actionSkipPhone))),
+	)
+	kb.OneTimeKeyboard = true
+	return kb
+}
+
+// sessionPriceText is the per-session cost line for tr's schedule, or "" when
+// sessions are covered by the subscription alone.
+func sessionPriceText(tr Trainer) string {
+	if tr.PriceModifier <= 0 {
+		return ""
+	}
+	return "💰 Стоимость занятия: " + formatMoney(tr.PriceModifier) + " сверх абонемента"
+}
+
+func trainerDetailsMessage(chatID int64, tr Trainer, hasPaid bool) telegram.MessageConfig {
+	text := tr.Name
+	if len(tr.Specialties) > 0 {
+		text += "\nСпециализация: " + strings.Join(tr.Specialties, ", ")
+	}
+	text += fmt.Sprintf("\n\nОписание: %s\n\nДостижения:\n• %s", tr.Bio, strings.Join(tr.Achievements, "\n• "))
+	if tr.PriceModifier > 0 {
+		text += "\n\nДоплата: +" + formatMoney(tr.PriceModifier)
+	}
+	if !tr.Active {
+		text += "\n\nТренер больше не принимает записи."
+	} else if tr.Away {
+		text += "\n\n⛔ Временно недоступен."
+	}
+	m := telegram.NewMessage(chatID, text)
+	m.ReplyMarkup = trainerDetailsKeyboard(tr, hasPaid)
+	return m
+}
+
+// maxCaptionLen is Telegram's limit on photo captions, in characters.
+const maxCaptionLen = 1024
+
+// showTrainerCard shows the trainer's details as the active menu: as a photo
+// with the details in the caption when the trainer has one, as text
+// otherwise or if the photo can't be sent.
+func showTrainerCard(bot Sender, chatID int64, tr Trainer, hasPaid bool, fromID int) error {
+	m := trainerDetailsMessage(chatID, tr, hasPaid)
+	if tr.PhotoURL == "" {
+		return showMenu(bot, m, fromID)
+	}
+	file, err := imageFile(tr.PhotoURL, tr.PhotoFileID)
+	if err != nil {
+		log.Printf("trainer %d photo: %v", tr.ID, err)
+		return showMenu(bot, m, fromID)
+	}
+	p := telegram.NewPhoto(chatID, file)
+	p.Caption = m.Text
+	if r := []rune(p.Caption); len(r) > maxCaptionLen {
+		p.Caption = string(r[:maxCaptionLen-1]) + "…"
+	}
+	p.ReplyMarkup = m.ReplyMarkup
+	sent, err := showPhotoMenu(bot, p)
+	if err != nil {
+		log.Printf("send trainer %d photo: %v", tr.ID, err)
+		return showMenu(bot, m, fromID)
+	}
+	if tr.PhotoFileID == "" && len(sent.Photo) > 0 {
+		fileID := sent.Photo[len(sent.Photo)-1].FileID
+		_ = updateTrainer(tr.ID, func(t *Trainer) {
+			// The photo may have been replaced while this one was uploading.
+			if t.PhotoURL == tr.PhotoURL {
+				t.PhotoFileID = fileID
+			}
+		})
+		_ = saveState()
+	}
+	return nil
+}
+
+// formatMoney renders an amount in the configured currency with thousands
+// separated by spaces, e.g. "25 000 ₸".
+func formatMoney(n int) string {
+	var b strings.Builder
+	if n < 0 {
+		b.WriteByte('-')
+		n = -n
+	}
+	s := strconv.Itoa(n)
+	for i, r := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + " " + currency
+}
+
+// priceText lists the subscription tiers.
+func priceText() string {
+	var b strings.Builder
+	b.WriteString("Прайсы абонементов:\n\n")
+	for _, t := range tiers {
+		fmt.Fprintf(&b, "• %s — %s / %s\n", t.Name, formatMoney(t.Price), daysText(t.DurationDays))
+	}
+	b.WriteString("\nНажмите \"Оплатить\" для симуляции оплаты.")
+	return b.String()
+}
+
+// parseStartPayload extracts the trainer id from a "/start trainer_<id>" deep
+// link payload.
+func parseStartPayload(payload string) (int, bool) {
+	idStr, ok := strings.CutPrefix(strings.TrimSpace(payload), "trainer_")
+	if !ok {
+		return 0, false
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+func trainersInlineKeyboard(hasPaid bool) telegram.InlineKeyboardMarkup {
+	return trainersPageKeyboard(hasPaid, 0, filterAll)
+}
+
+// trainerFilter narrows the trainers list to one session format.
+type trainerFilter string
+
+const (
+	filterAll      trainerFilter = "all"
+	filterGroup    trainerFilter = "group"
+	filterPersonal trainerFilter = "personal"
+)
+
+func (f trainerFilter) match(t Trainer) bool {
+	switch f {
+	case filterGroup:
+		return t.GroupClass
+	case filterPersonal:
+		return !t.GroupClass
+	}
+	return true
+}
+
+// trainerIcon tells group-class trainers from personal ones in lists.
+func trainerIcon(t Trainer) string {
+	if t.GroupClass {
+		return "👥 "
+	}
+	return "👤 "
+}
+
+// trainerRow is a trainer's line in a list: the name opens the card, and
+// paying users get a booking shortcut next to it.
+func trainerRow(t Trainer, hasPaid bool) []telegram.InlineKeyboardButton {
+	if t.Away {
+		return telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData("⛔ "+t.Name+" — временно недоступен", fmt.Sprintf("trainer_%d", t.ID)),
+		)
+	}
+	row := []telegram.InlineKeyboardButton{
+		telegram.NewInlineKeyboardButtonData(trainerIcon(t)+t.Name, fmt.Sprintf("trainer_%d", t.ID)),
+	}
+	if hasPaid {
+		row = append(row, telegram.NewInlineKeyboardButtonData("🗓 Запись", fmt.Sprintf("book_%d", t.ID)))
+	}
+	return row
+}
+
+// minSearchLen is the shortest /find query; shorter ones match too much.
+const minSearchLen = 2
+
+// searchTrainers returns the active trainers whose name, bio, achievements
+// or specialties contain query, ignoring case.
+func searchTrainers(query string) []Trainer {
+	q := strings.ToLower(strings.TrimSpace(query))
+	var res []Trainer
+	for _, t := range activeTrainers(snapshot().Trainers) {
+		fields := append([]string{t.Name, t.Bio}, t.Achievements...)
+		fields = append(fields, t.Specialties...)
+		for _, f := range fields {
+			if strings.Contains(strings.ToLower(f), q) {
+				res = append(res, t)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func handleFind(bot Sender, msg *telegram.Message, user User) {
+	query := strings.TrimSpace(msg.CommandArguments())
+	if utf8.RuneCountInString(query) < minSearchLen {
+		_ = replyError(bot, msg.Chat.ID, fmt.Sprintf("Использование: /find <запрос>, не короче %d символов.", minSearchLen))
+		return
+	}
+	found := searchTrainers(query)
+	if len(found) == 0 {
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "По запросу «"+query+"» ничего не найдено."))
+		return
+	}
+	hasPaid := user.IsActive()
+	rows := make([][]telegram.InlineKeyboardButton, 0, len(found)+1)
+	for _, t := range found {
+		rows = append(rows, trainerRow(t, hasPaid))
+	}
+	rows = append(rows, telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu")))
+	m := telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Найдено тренеров: %d", len(found)))
+	m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(rows...)
+	_ = send(bot, m)
+}
+
+// trainerFreeCount is how many of the trainer's sessions can still be booked
+// today: zero for unknown, inactive, away or blacked-out trainers.
+func trainerFreeCount(id int) int {
+	tr, _ := getTrainerByID(id)
+	if tr == nil || tr.bookable() != nil || isBlackout(*tr, today()) {
+		return 0
+	}
+	current := now().In(gymLoc).Format("15:04")
+	n := 0
+	for _, s := range tr.Slots {
+		if s > current {
+			n++
+		}
+	}
+	return n
+}
+
+// handleTrainersList answers /trainers with a plain-text overview of the
+// active trainers, most free sessions first.
+func handleTrainersList(bot Sender, msg *telegram.Message) {
+	trainers := activeTrainers(snapshot().Trainers)
+	if len(trainers) == 0 {
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Сейчас нет доступных тренеров."))
+		return
+	}
+	free := make(map[int]int, len(trainers))
+	for _, t := range trainers {
+		free[t.ID] = trainerFreeCount(t.ID)
+	}
+	sort.SliceStable(trainers, func(i, j int) bool { return free[trainers[i].ID] > free[trainers[j].ID] })
+	var b strings.Builder
+	b.WriteString("Тренеры и свободное время на сегодня:")
+	for _, t := range trainers {
+		fmt.Fprintf(&b, "\n• %s — свободно: %d", t.Name, free[t.ID])
+		if len(t.Specialties) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(t.Specialties, ", "))
+		}
+		if t.Away {
+			b.WriteString(" (временно недоступен)")
+		}
+	}
+	_ = sendLong(bot, msg.Chat.ID, b.String())
+}
+
+// trainersPageKeyboard shows trainersPerPage trainers starting at page
+// (0-based, clamped to the valid range) with ◀/▶ navigation when the list
+// doesn't fit on one page.
+func trainersPageKeyboard(hasPaid bool, page int, filter trainerFilter) telegram.InlineKeyboardMarkup {
+	all := activeTrainers(snapshot().Trainers)
+	var trainers []Trainer
+	hasGroup := false
+	for _, t := range all {
+		hasGroup = hasGroup || t.GroupClass
+		if filter.match(t) {
+			trainers = append(trainers, t)
+		}
+	}
+
+	pages := (len(trainers) + trainersPerPage - 1) / trainersPerPage
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	from := page * trainersPerPage
+	to := from + trainersPerPage
+	if to > len(trainers) {
+		to = len(trainers)
+	}
+
+	rows := [][]telegram.InlineKeyboardButton{}
+	// The format filter only makes sense once there are group classes.
+	if hasGroup {
+		var frow []telegram.InlineKeyboardButton
+		for _, f := range []struct {
+			f     trainerFilter
+			label string
+		}{{filterAll, "Все"}, {filterGroup, "👥 Групповые"}, {filterPersonal, "👤 Персональные"}} {
+			if f.f == filter {
+				f.label = "• " + f.label
+			}
+			frow = append(frow, telegram.NewInlineKeyboardButtonData(f.label, fmt.Sprintf("trainerspage_0_%s", f.f)))
+		}
+		rows = append(rows, frow)
+	}
+	if len(trainers) == 0 {
+		rows = append(rows, telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("Нет тренеров в этом формате", "noop")))
+	}
+	for _, t := range trainers[from:to] {
+		rows = append(rows, trainerRow(t, hasPaid))
+	}
+	if pages > 1 {
+		nav := []telegram.InlineKeyboardButton{}
+		if page > 0 {
+			nav = append(nav, telegram.NewInlineKeyboardButtonData("◀", fmt.Sprintf("trainerspage_%d_%s", page-1, filter)))
+		}
+		nav = append(nav, telegram.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", page+1, pages), "noop"))
+		if page < pages-1 {
+			nav = append(nav, telegram.NewInlineKeyboardButtonData("▶", fmt.Sprintf("trainerspage_%d_%s", page+1, filter)))
+		}
+		rows = append(rows, nav)
+	}
+	if !hasPaid {
+		rows = append(rows, telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("🧪 Демо записи", "demo")))
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu")})
+	return telegram.NewInlineKeyboardMarkup(rows...)
+}
+
+func trainerDetailsKeyboard(t Trainer, hasPaid bool) telegram.InlineKeyboardMarkup {
+	if t.bookable() != nil {
+		return telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData("⬅️ Назад", "trainers"),
+		))
+	}
+	row := []telegram.InlineKeyboardButton{}
+	if hasPaid {
+		row = append(row, telegram.NewInlineKeyboardButtonData("🗓 Запись", fmt.Sprintf("book_%d", t.ID)))
+	} else {
+		row = append(row, telegram.NewInlineKeyboardButtonData("🧪 Демо записи", fmt.Sprintf("demo_%d", t.ID)))
+	}
+	row = append(row, telegram.NewInlineKeyboardButtonData("⬅️ Назад", "trainers"))
+	rows := [][]telegram.InlineKeyboardButton{row}
+	if hasPaid {
+		rows = append([][]telegram.InlineKeyboardButton{{
+			telegram.NewInlineKeyboardButtonData("✉️ Написать", fmt.Sprintf("contact_%d", t.ID)),
+		}}, rows...)
+	}
+	if len(t.Slots) == 0 || isBlackout(t, today()) {
+		rows = append([][]telegram.InlineKeyboardButton{{
+			telegram.NewInlineKeyboardButtonData("🔔 Уведомить о свободных", fmt.Sprintf("subscribe_%d", t.ID)),
+		}}, rows...)
+	}
+	return telegram.NewInlineKeyboardMarkup(rows...)
+}
+
+func myBookingsKeyboard(bookings []Booking) telegram.InlineKeyboardMarkup {
+	rows := [][]telegram.InlineKeyboardButton{}
+	for _, b := range bookings {
+		rows = append(rows, []telegram.InlineKeyboardButton{
+			telegram.NewInlineKeyboardButtonData("❌ Отменить "+b.TimeSlot, fmt.Sprintf("bcancel_%d", b.ID)),
+			telegram.NewInlineKeyboardButtonData("🔁 Перенести", fmt.Sprintf("bmove_%d", b.ID)),
+		})
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu")})
+	return telegram.NewInlineKeyboardMarkup(rows...)
+}
+
+func myBookingsText(bookings []Booking) string {
+	if len(bookings) == 0 {
+		return "У вас нет активных записей."
+	}
+	var sb strings.Builder
+	sb.WriteString("Ваши записи на сегодня:\n")
+	for _, b := range bookings {
+		name := fmt.Sprintf("#%d", b.Trainer)
+		if tr, _ := getTrainerByID(b.Trainer); tr != nil {
+			name = tr.Name
+			if !tr.Active {
+				name += " (больше не работает)"
+			}
+		}
+		sb.WriteString(fmt.Sprintf("\n• %s — %s, код %s", formatSessionFor(b.UserID, b.Trainer, b.Date, b.TimeSlot), name, b.Code))
+	}
+	return sb.String()
+}
+
+func scheduleKeyboard(trainerID int) telegram.InlineKeyboardMarkup {
+	kb := slotsKeyboard(trainerID, func(s string) string {
+		return fmt.Sprintf("slot_%d_%s", trainerID, s)
+	}, "trainers")
+	if len(kb.InlineKeyboard) > 1 {
+		multi := telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("☑️ Выбрать несколько", fmt.Sprintf("multi_%d", trainerID)))
+		last := len(kb.InlineKeyboard) - 1
+		kb.InlineKeyboard = append(kb.InlineKeyboard[:last], multi, kb.InlineKeyboard[last])
+	}
+	return kb
+}
+
+// slotTakenMessage offers the slots nearest to one that was just taken, so
+// the user can pick another time in one tap. If the trainer is fully booked
+// it says so and points back to the trainer list.
+func slotTakenMessage(chatID int64, trainerID int, slot string) telegram.MessageConfig {
+	alts := nearestSlots(trainerID, slot, slotSuggestions)
+	if len(alts) == 0 {
+		m := telegram.NewMessage(chatID, fmt.Sprintf("Время %s уже заняли, а других свободных слотов у этого тренера сегодня нет. Выберите другого тренера:", slot))
+		m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData("⬅️ К тренерам", "trainers"),
+		))
+		return m
+	}
+	row := []telegram.InlineKeyboardButton{}
+	for _, s := range alts {
+		row = append(row, telegram.NewInlineKeyboardButtonData(s, fmt.Sprintf("slot_%d_%s", trainerID, s)))
+	}
+	m := telegram.NewMessage(chatID, fmt.Sprintf("Время %s уже заняли. Ближайшее свободное: %s.", slot, strings.Join(alts, ", ")))
+	m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(row, telegram.NewInlineKeyboardRow(
+		telegram.NewInlineKeyboardButtonData("🗓 Всё расписание", fmt.Sprintf("book_%d", trainerID)),
+	))
+	return m
+}
+
+func rescheduleKeyboard(b Booking) telegram.InlineKeyboardMarkup {
+	return slotsKeyboard(b.Trainer, func(s string) string {
+		return fmt.Sprintf("bmoveto_%d_%s", b.ID, s)
+	}, "mybookings")
+}
+
+func slotsKeyboard(trainerID int, data func(slot string) string, back string) telegram.InlineKeyboardMarkup {
+	var slots []string
+	tr, _ := getTrainerByID(trainerID)
+	if tr != nil && !isBlackout(*tr, today()) {
+		slots = tr.Slots
+	}
+
+	rows := [][]telegram.InlineKeyboardButton{}
+	row := []telegram.InlineKeyboardButton{}
+	for i, s := range slots {
+		row = append(row, telegram.NewInlineKeyboardButtonData(slotRange(*tr, s), data(s)))
+		if (i+1)%slotsPerRow == 0 {
+			rows = append(rows, row)
+			row = []telegram.InlineKeyboardButton{}
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []telegram.InlineKeyboardButton{telegram.NewInlineKeyboardButtonData("⬅️ Назад", back)})
+	return telegram.NewInlineKeyboardMarkup(rows...)
+}
+
+func pricingKeyboard() telegram.InlineKeyboardMarkup {
+	var rows [][]telegram.InlineKeyboardButton
+	for _, t := range tiers {
+		rows = append(rows, telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData(fmt.Sprintf("Оплатить %s (%s)", t.Name, formatMoney(t.Price)), "pay_"+t.Code),
+		))
+	}
+	rows = append(rows, telegram.NewInlineKeyboardRow(
+		telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu"),
+	))
+	return telegram.NewInlineKeyboardMarkup(rows...)
+}
+
+// Sender is the part of *telegram.BotAPI the handlers use. Keeping handlers
+// on this interface lets them run against a fake client.
+type Sender interface {
+	Send(c telegram.Chattable) (telegram.Message, error)
+	Request(c telegram.Chattable) (*telegram.APIResponse, error)
+}
+
+type menuAction string
+
+const (
+	actionTrainers   menuAction = "trainers"
+	actionPrices     menuAction = "prices"
+	actionMyBookings menuAction = "mybookings"
+	actionSkipPhone  menuAction = "skipphone"
+	actionEarliest   menuAction = "earliest"
+	actionRecent     menuAction = "recent"
+	actionPay        menuAction = "pay"
+	actionCancel     menuAction = "cancel"
+	actionSupport    menuAction = "support"
+)
+
+// menuSynonyms maps normalized user input to a main-menu action. Keys must be
+// in the form produced by normalizeText.
+var menuSynonyms = map[string]menuAction{
+	"тренеры":             actionTrainers,
+	"тренера":             actionTrainers,
+	"тренер":              actionTrainers,
+	"trainers":            actionTrainers,
+	"trainer":             actionTrainers,
+	"прайс абонементов":   actionPrices,
+	"прайс":               actionPrices,
+	"цены":                actionPrices,
+	"абонемент":           actionPrices,
+	"абонементы":          actionPrices,
+	"price":               actionPrices,
+	"prices":              actionPrices,
+	"мои записи":          actionMyBookings,
+	"записи":              actionMyBookings,
+	"my bookings":         actionMyBookings,
+	"bookings":            actionMyBookings,
+	"пропустить":          actionSkipPhone,
+	"ближайшее свободное": actionEarliest,
+	"ближайшее":           actionEarliest,
+	"недавние":            actionRecent,
+	"recent":              actionRecent,
+	"оплатить":            actionPay,
+	"оплата":              actionPay,
+	"pay":                 actionPay,
+	"отменить запись":     actionCancel,
+	"отмена":              actionCancel,
+	"cancel":              actionCancel,
+	"поддержка":           actionSupport,
+	"помощь":              actionSupport,
+	"support":             actionSupport,
+}
+
+// normalizeText lowercases s, drops punctuation and symbols (including
+// emoji) and collapses whitespace.
+func normalizeText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return ' '
+		}
+		return unicode.ToLower(r)
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// menuLabels are the reply-keyboard labels per interface language. The text
+// router accepts a label in any language, so a keyboard keeps working after
+// the user's language changes.
+var menuLabels = map[string]map[menuAction]string{
+	"ru": {
+		actionTrainers:   "Тренеры",
+		actionPrices:     "Прайс абонементов",
+		actionMyBookings: "Мои записи",
+		actionCancel:     "❌ Отменить запись",
+		actionEarliest:   "⚡ Ближайшее свободное",
+		actionRecent:     "🕘 Недавние",
+		actionPay:        "💳 Оплатить",
+		actionSupport:    "🆘 Поддержка",
+		actionSkipPhone:  "Пропустить",
+	},
+	"kk": {
+		actionTrainers:   "Жаттықтырушылар",
+		actionPrices:     "Абонемент бағасы",
+		actionMyBookings: "Менің жазылуларым",
+		actionCancel:     "❌ Жазылудан бас тарту",
+		actionEarliest:   "⚡ Ең жақын бос уақыт",
+		actionRecent:     "🕘 Соңғылар",
+		actionPay:        "💳 Төлеу",
+		actionSupport:    "🆘 Қолдау",
+		actionSkipPhone:  "Өткізіп жіберу",
+	},
+	"en": {
+		actionTrainers:   "Trainers",
+		actionPrices:     "Prices",
+		actionMyBookings: "My bookings",
+		actionCancel:     "❌ Cancel a booking",
+		actionEarliest:   "⚡ Earliest free slot",
+		actionRecent:     "🕘 Recent",
+		actionPay:        "💳 Pay",
+		actionSupport:    "🆘 Support",
+		actionSkipPhone:  "Skip",
+	},
+}
+
+// menuLabel is a's label in lang, falling back to Russian.
+func menuLabel(lang string, a menuAction) string {
+	if l, ok := menuLabels[lang][a]; ok {
+		return l
+	}
+	return menuLabels["ru"][a]
+}
+
+func menuActionFor(text string) (menuAction, bool) {
+	norm := normalizeText(text)
+	if a, ok := menuSynonyms[norm]; ok {
+		return a, true
+	}
+	for _, labels := range menuLabels {
+		for a, l := range labels {
+			if normalizeText(l) == norm {
+				return a, true
+			}
+		}
+	}
+	return "", false
+}
+
+func isMenuText(text string) bool {
+	_, ok := menuActionFor(text)
+	return ok
+}
+
+func handleContactMessage(bot Sender, msg *telegram.Message, name string, trainerID int) {
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		setConversation(msg.From.ID, ConversationState{Step: stepContactTrainer, TrainerID: trainerID})
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Пожалуйста, отправьте текстовое сообщение."))
+		return
+	}
+	tr, _ := getTrainerByID(trainerID)
+	if tr == nil {
+		_ = replyError(bot, msg.Chat.ID, "Тренер не найден")
+		return
+	}
+	fwd := fmt.Sprintf("✉️ Сообщение для тренера %s\nОт: %s (id %d)\n\n%s", tr.Name, name, msg.From.ID, text)
+	if _, err := sendSync(bot, telegram.NewMessage(staffChat, fwd)); err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось отправить сообщение, попробуйте позже.")
+		return
+	}
+	reply := telegram.NewMessage(msg.Chat.ID, "Сообщение передано тренеру. Спасибо!")
+	reply.ReplyMarkup = mainMenuKeyboard(userLang(msg.From.ID))
+	_ = send(bot, reply)
+}
+
+func handleBookingNote(bot Sender, msg *telegram.Message, bookingID int) {
+	note := sanitizeNote(msg.Text)
+	if note == "" {
+		setConversation(msg.From.ID, ConversationState{Step: stepBookingNote, BookingID: bookingID})
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Пожалуйста, отправьте комментарий текстом или нажмите \"Пропустить\"."))
+		return
+	}
+	b, err := setBookingNote(msg.From.ID, bookingID, note)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось сохранить комментарий: "+err.Error())
+		return
+	}
+	_ = saveState()
+	notifyStaffBooking(bot, b, "📝 Комментарий к записи")
+	reply := telegram.NewMessage(msg.Chat.ID, "Комментарий сохранён, тренер его увидит.")
+	reply.ReplyMarkup = mainMenuKeyboard(userLang(msg.From.ID))
+	_ = send(bot, reply)
+}
+
+// staffBookingText describes b for staff, as HTML.
+func staffBookingText(b Booking) string {
+	trainer := fmt.Sprintf("#%d", b.Trainer)
+	if tr, _ := getTrainerByID(b.Trainer); tr != nil {
+		trainer = tr.Name
+	}
+	user := fmt.Sprintf("id %d", b.UserID)
+	stateMu.Lock()
+	if u, ok := state.Users[b.UserID]; ok {
+		user = fmt.Sprintf("%s (id %d)", u.Name, u.ID)
+		if u.Phone != "" {
+			user += ", тел. " + u.Phone
+		}
+	}
+	stateMu.Unlock()
+
+	text := fmt.Sprintf("Запись #%d, код %s\nТренер: %s\nВремя: %s\nКлиент: %s", b.ID, b.Code, escapeHTML(trainer), formatSession(b.Trainer, b.Date, b.TimeSlot), escapeHTML(user))
+	if b.Note != "" {
+		text += "\nКомментарий: " + escapeHTML(b.Note)
+	}
+	return text
+}
+
+func notifyStaffBooking(bot Sender, b Booking, title string) {
+	if staffChat == 0 {
+		return
+	}
+	_ = notify(bot, htmlMessage(staffChat, "<b>"+escapeHTML(title)+"</b>\n\n"+staffBookingText(b)))
+}
+
+// notifySubscribers offers a slot that was just freed today to the
+// subscribers in ids, one at a time (see offers.go).
+func notifySubscribers(bot Sender, ids []int64, trainerID int, slot string) {
+	if len(ids) == 0 {
+		return
+	}
+	tr, _ := getTrainerByID(trainerID)
+	if tr == nil {
+		return
+	}
+	h, ok := startOffer(ids, tr.ID, slot)
+	if !ok {
+		return
+	}
+	_ = saveState()
+	sendOffer(bot, h)
+}
+
+// validateToken checks that token looks like "<numeric bot id>:<secret>"
+// before it is sent to Telegram.
+func validateToken(token string) error {
+	if token == "" {
+		return errors.New("not set")
+	}
+	id, secret, ok := strings.Cut(token, ":")
+	if !ok || secret == "" {
+		return errors.New("expected the form <bot id>:<secret>")
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return fmt.Errorf("bot id %q is not a number", id)
+	}
+	if strings.ContainsAny(token, " \t\r\n") {
+		return errors.New("contains whitespace")
+	}
+	return nil
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", os.Getenv("DRY_RUN") == "1", "run without Telegram: log outgoing messages, read updates from -script")
+	script := flag.String("script", "", "dry-run update script (default stdin)")
+	flag.Parse()
+
+	if err := initStatePath(); err != nil {
+		log.Fatalf("state path: %v", err)
+	}
+	if err := loadState(); err != nil {
+		log.Fatalf("load state: %v", err)
+	}
+	loadConfig()
+	if err := validateConfig(); err != nil {
+		log.Fatal(err)
+	}
+	// Catch up if the bot was down at the last rollover.
+	if rolloverDay(today()) {
+		_ = saveState()
+	}
+	go runDailyRollover()
+	go sweepConversations(time.Minute)
+	go sweepNoShows(15 * time.Minute)
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		go serveMetrics(addr)
+	}
+	if addr := os.Getenv("ADMIN_API_ADDR"); addr != "" {
+		go serveAdminAPI(addr, os.Getenv("ADMIN_API_TOKEN"))
+	}
+
+	var bot *telegram.BotAPI
+	var err error
+	if *dryRun {
+		bot, err = newDryRunBot(*script)
+	} else {
+		token := os.Getenv("TELEGRAM_TOKEN")
+		if err := validateToken(token); err != nil {
+			log.Fatalf("TELEGRAM_TOKEN: %v. Create a bot with @BotFather in Telegram, copy the token it gives you (it looks like 123456789:AA...) and export it as TELEGRAM_TOKEN.", err)
+		}
+		bot, err = telegram.NewBotAPI(token)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	bot.Debug = false
+	log.Printf("Authorized on account %s", bot.Self.UserName)
+	botUsername = bot.Self.UserName
+	startThrottle(sendRate)
+	outbox = startOutbox(outboxWorkers, outboxQueueSize)
+	go sweepReminders(bot, time.Hour)
+	go sweepHolds(bot, 15*time.Second)
+	go watchPersistence(bot, 30*time.Second)
+	go runWeeklyDigest(bot)
+
+	// Resume after the last handled update so Telegram doesn't resend the
+	// backlog; alreadyHandled still guards against anything that slips in.
+	u := telegram.NewUpdate(snapshot().LastUpdateID + 1)
+	u.Timeout = 60
+	updates := bot.GetUpdatesChan(u)
 
 	for update := range updates {
-		if update.Message != nil {
-			userID := update.Message.From.ID
-			name := strings.TrimSpace(update.Message.From.FirstName + " " + update.Message.From.LastName)
-			if name == "" {
-				name = update.Message.From.UserName
+		if alreadyHandled(update.UpdateID) {
+			log.Printf("skip already handled update %d", update.UpdateID)
+			continue
+		}
+		handleUpdate(bot, update)
+		markHandled(update.UpdateID)
+		if err := saveState(); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+	outbox.close()
+}
+
+// alreadyHandled reports whether the update was processed before, possibly by
+// a previous run that crashed before Telegram saw the new offset.
+func alreadyHandled(updateID int) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return updateID <= state.LastUpdateID
+}
+
+func markHandled(updateID int) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if updateID > state.LastUpdateID {
+		state.LastUpdateID = updateID
+	}
+}
+
+func handleUpdate(bot Sender, update telegram.Update) {
+	metricUpdates.Add(1)
+	if em := update.EditedMessage; em != nil {
+		// An edited command is run as if it was sent anew, so fixing a typo
+		// in "/strat" works. Other edits are not replayed: the original text
+		// was already acted on, and applying it twice could book or cancel
+		// twice. The user is told to send a new message instead.
+		if !em.IsCommand() {
+			if em.Chat != nil && em.Chat.IsPrivate() {
+				_ = send(bot, telegram.NewMessage(em.Chat.ID, "Изменённые сообщения не обрабатываются — отправьте новое сообщение."))
 			}
+			return
+		}
+		update.Message, update.EditedMessage = em, nil
+	}
+	if from := update.SentFrom(); from != nil && isBanned(from.ID) {
+		if cq := update.CallbackQuery; cq != nil {
+			_ = answerCallback(bot, cq.ID, "Вы заблокированы.", false)
+		} else if update.Message != nil {
+			_ = send(bot, telegram.NewMessage(update.Message.Chat.ID, "Вы заблокированы. Обратитесь к администратору зала."))
+		}
+		return
+	}
+	if readOnly.Load() && changesState(update) {
+		refuseReadOnly(bot, update)
+		return
+	}
+	if update.InlineQuery != nil {
+		handleInlineQuery(bot, update.InlineQuery)
+		return
+	}
+	if update.Message != nil {
+		userID := update.Message.From.ID
+		name := strings.TrimSpace(update.Message.From.FirstName + " " + update.Message.From.LastName)
+		if name == "" {
+			name = update.Message.From.UserName
+		}
 
-			user := getOrCreateUser(userID, name)
+		user := getOrCreateUser(userID, name, update.Message.From.LanguageCode, update.Message.Chat)
 
-			if update.Message.IsCommand() || update.Message.Text == "/start" {
-				welcome := fmt.Sprintf("Вас приветствует фитнес зал %s!\nВыберите раздел ниже.", gymName)
-				msg := telegram.NewMessage(update.Message.Chat.ID, welcome)
-				msg.ReplyMarkup = mainMenuKeyboard()
-				_ = send(bot, msg)
-				continue
+		if relayTicketReply(bot, update.Message) {
+			return
+		}
+
+		if c := update.Message.Contact; c != nil {
+			if c.UserID != userID {
+				_ = send(bot, telegram.NewMessage(update.Message.Chat.ID, "Пожалуйста, отправьте свой номер кнопкой \"📱 Поделиться номером\"."))
+				return
+			}
+			setUserPhone(userID, c.PhoneNumber)
+			_ = saveState()
+			msg := telegram.NewMessage(update.Message.Chat.ID, "Спасибо! Номер сохранён.")
+			msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+			_ = send(bot, msg)
+			return
+		}
+
+		if update.Message.IsCommand() || isMenuText(update.Message.Text) {
+			clearConversation(userID)
+		} else if st, ok := getConversation(userID); ok && handleConversation(bot, update.Message, name, st) {
+			return
+		}
+
+		if update.Message.IsCommand() || update.Message.Text == "/start" {
+			switch update.Message.Command() {
+			case "blackout":
+				handleBlackout(bot, update.Message)
+				return
+			case "resetuser":
+				handleResetUser(bot, update.Message)
+				return
+			case "grant":
+				handleGrant(bot, update.Message)
+				return
+			case "edittrainer":
+				handleEditTrainer(bot, update.Message)
+				return
+			case "deltrainer":
+				handleDelTrainer(bot, update.Message)
+				return
+			case "revenue":
+				handleRevenue(bot, update.Message)
+				return
+			case "heatmap":
+				handleHeatmap(bot, update.Message)
+				return
+			case "setrole":
+				handleSetRole(bot, update.Message)
+				return
+			case "dumpstate":
+				handleDumpState(bot, update.Message)
+				return
+			case "auditlog":
+				handleAuditLog(bot, update.Message)
+				return
+			case "ban":
+				handleBan(bot, update.Message)
+				return
+			case "reload":
+				handleReload(bot, update.Message)
+				return
+			case "info":
+				handleInfo(bot, update.Message)
+				return
+			case "trainerstatus":
+				handleTrainerStatus(bot, update.Message)
+				return
+			case "cancel":
+				handleCancel(bot, update.Message)
+				return
+			case "redeem":
+				handleRedeem(bot, update.Message)
+				return
+			case "promo":
+				handlePromo(bot, update.Message)
+				return
+			case "checkin":
+				handleCheckIn(bot, update.Message)
+				return
+			case "setlimit":
+				handleSetLimit(bot, update.Message)
+				return
+			case "trainers":
+				handleTrainersList(bot, update.Message)
+				return
+			case "demo":
+				handleDemo(bot, update.Message)
+				return
+			case "find":
+				handleFind(bot, update.Message, user)
+				return
+			case "roster":
+				handleRoster(bot, update.Message)
+				return
+			case "profile":
+				handleProfile(bot, update.Message)
+				return
+			case "unban":
+				handleUnban(bot, update.Message)
+				return
 			}
+			if update.Message.Command() == "start" && update.Message.CommandArguments() == "" && !startAllowed(userID) {
+				return
+			}
+			if update.Message.Command() == "start" || update.Message.Text == "/start" {
+				sendWelcomeImage(bot, update.Message.Chat.ID)
+			}
+			if id, ok := parseStartPayload(update.Message.CommandArguments()); ok && update.Message.Command() == "start" {
+				if tr, _ := getTrainerByID(id); tr != nil {
+					msg := telegram.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Вас приветствует фитнес зал %s!\nВыберите раздел ниже.", gymName))
+					msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+					_ = send(bot, msg)
+					_ = showTrainerCard(bot, update.Message.Chat.ID, *tr, user.IsActive(), 0)
+					return
+				}
+			}
+			if code, ok := parseReferralPayload(update.Message.CommandArguments()); ok && update.Message.Command() == "start" {
+				if ref, rewarded, ok := creditReferral(userID, code); ok {
+					notifyReferrer(bot, ref, rewarded)
+				}
+			}
+			if update.Message.Command() == "start" && markOnboarded(userID) {
+				_ = saveState()
+				_ = send(bot, onboardingMessage(update.Message.Chat.ID, 0))
+				return
+			}
+			sendMainWelcome(bot, update.Message.Chat.ID, userID)
+			return
+		}
+
+		if strings.TrimSpace(update.Message.Text) == "" {
+			// Stickers, photos, voice notes and the like carry no text.
+			msg := telegram.NewMessage(update.Message.Chat.ID, "Пожалуйста, используйте кнопки меню.")
+			msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+			_ = send(bot, msg)
+			return
+		}
 
-			switch update.Message.Text {
-			case "Тренеры":
-				msg := telegram.NewMessage(update.Message.Chat.ID, "Наши тренеры:")
-				msg.ReplyMarkup = mainMenuKeyboard()
-				msg.ReplyMarkup = nil
-				msg.Text = "Наши тренеры (нажмите имя, чтобы узнать подробнее):"
-				msgReply := telegram.NewMessage(update.Message.Chat.ID, msg.Text)
-				msgReply.ReplyMarkup = trainersInlineKeyboard(user.HasPaid)
-				_ = send(bot, msgReply)
-			case "Прайс абонементов":
-				msg := telegram.NewMessage(update.Message.Chat.ID, priceText)
-				msg.ReplyMarkup = pricingKeyboard()
+		action, _ := menuActionFor(update.Message.Text)
+		switch action {
+		case actionTrainers:
+			msg := telegram.NewMessage(update.Message.Chat.ID, "Наши тренеры:")
+			msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+			msg.ReplyMarkup = nil
+			msg.Text = "Наши тренеры (нажмите имя, чтобы узнать подробнее):"
+			msgReply := telegram.NewMessage(update.Message.Chat.ID, msg.Text)
+			msgReply.ReplyMarkup = trainersInlineKeyboard(user.IsActive())
+			_ = showMenu(bot, msgReply, 0)
+		case actionPrices:
+			msg := telegram.NewMessage(update.Message.Chat.ID, priceText())
+			msg.ReplyMarkup = pricingKeyboard()
+			_ = send(bot, msg)
+		case actionPay:
+			msg := telegram.NewMessage(update.Message.Chat.ID, "Выберите абонемент для оплаты:")
+			msg.ReplyMarkup = pricingKeyboard()
+			_ = send(bot, msg)
+		case actionEarliest:
+			if !user.IsActive() {
+				_ = replyError(bot, update.Message.Chat.ID, "Чтобы записаться, сначала оплатите абонемент в разделе \"Прайс абонементов\".")
+				return
+			}
+			trainerID, slot, ok := earliestAvailableFor(userID, now())
+			if !ok {
+				msg := telegram.NewMessage(update.Message.Chat.ID, "На сегодня свободного времени больше нет. Попробуйте завтра или подпишитесь на уведомления у тренера.")
+				msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
 				_ = send(bot, msg)
-			default:
-				msg := telegram.NewMessage(update.Message.Chat.ID, "Не понял команду. Пожалуйста, выберите пункт меню.")
-				msg.ReplyMarkup = mainMenuKeyboard()
+				return
+			}
+			tr, _ := getTrainerByID(trainerID)
+			msg := telegram.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ближайшее свободное время: %s, тренер %s.", formatSessionFor(userID, tr.ID, today(), slot), tr.Name))
+			msg.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+				telegram.NewInlineKeyboardButtonData("🗓 Записаться", fmt.Sprintf("slot_%d_%s", trainerID, slot)),
+			))
+			_ = send(bot, msg)
+		case actionSkipPhone:
+			msg := telegram.NewMessage(update.Message.Chat.ID, "Хорошо! Выберите раздел ниже.")
+			msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+			_ = send(bot, msg)
+		case actionSupport:
+			startSupport(bot, update.Message.Chat.ID, userID)
+		case actionCancel:
+			_ = send(bot, cancelPickerMessage(update.Message.Chat.ID, userID, ""))
+		case actionRecent:
+			recent := recentTrainers(userID)
+			if len(recent) == 0 {
+				msg := telegram.NewMessage(update.Message.Chat.ID, "Вы ещё не открывали карточки тренеров.")
+				msg.ReplyMarkup = trainersInlineKeyboard(user.IsActive())
 				_ = send(bot, msg)
+				return
+			}
+			msg := telegram.NewMessage(update.Message.Chat.ID, "Недавно просмотренные тренеры:")
+			msg.ReplyMarkup = recentTrainersKeyboard(recent)
+			_ = showMenu(bot, msg, 0)
+		case actionMyBookings:
+			bookings := userBookings(userID)
+			msg := telegram.NewMessage(update.Message.Chat.ID, myBookingsText(bookings))
+			msg.ReplyMarkup = myBookingsKeyboard(bookings)
+			_ = showMenu(bot, msg, 0)
+		default:
+			msg := telegram.NewMessage(update.Message.Chat.ID, "Не понял команду. Пожалуйста, выберите пункт меню.")
+			msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+			_ = send(bot, msg)
+		}
+	}
+
+	if update.CallbackQuery != nil {
+		cq := update.CallbackQuery
+		if cq.Message == nil {
+			// Buttons on inline-mode messages, or on messages too old for
+			// Telegram to include, come without the message and its chat.
+			_ = answerCallback(bot, cq.ID, "Кнопка устарела. Отправьте /start, чтобы открыть меню.", true)
+			return
+		}
+		userID := cq.From.ID
+		user := getOrCreateUser(userID, cq.From.FirstName, cq.From.LanguageCode, cq.Message.Chat)
+
+		data := cq.Data
+		if !isKnownCallback(data) {
+			log.Printf("unknown callback %q from %d", data, userID)
+			_ = answerCallback(bot, cq.ID, "Действие недоступно. Откройте меню заново.", false)
+			return
+		}
+		// Booking callbacks answer last so a failure can be shown as an
+		// alert popup instead of a new chat message.
+		alert := ""
+		if isBookingCallback(data) {
+			defer func() { _ = answerCallback(bot, cq.ID, alert, alert != "") }()
+		} else {
+			_ = answerCallback(bot, cq.ID, "", false)
+		}
+		if data == "menu" {
+			m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Вас приветствует фитнес зал %s!", gymName))
+			m.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+			_ = send(bot, m)
+			return
+		}
+		if data == "onboard_done" {
+			sendMainWelcome(bot, cq.Message.Chat.ID, userID)
+			return
+		}
+		if data == "demo" || strings.HasPrefix(data, "demo_") || strings.HasPrefix(data, "demoslot_") || strings.HasPrefix(data, "democonfirm_") {
+			handleDemoCallback(bot, cq, data)
+			return
+		}
+
+		if data == "onboard_prices" {
+			sendMainWelcome(bot, cq.Message.Chat.ID, userID)
+			m := telegram.NewMessage(cq.Message.Chat.ID, priceText())
+			m.ReplyMarkup = pricingKeyboard()
+			_ = send(bot, m)
+			return
+		}
+		if strings.HasPrefix(data, "onboard_") {
+			var step int
+			fmt.Sscanf(strings.TrimPrefix(data, "onboard_"), "%d", &step)
+			if step < 1 || step >= len(onboardingSteps) {
+				return
 			}
+			_ = send(bot, onboardingMessage(cq.Message.Chat.ID, step))
+			return
+		}
+		if data == "trainers" {
+			m := telegram.NewMessage(cq.Message.Chat.ID, "Наши тренеры (нажмите имя, чтобы узнать подробнее):")
+			m.ReplyMarkup = trainersInlineKeyboard(user.IsActive())
+			_ = showMenu(bot, m, cq.Message.MessageID)
+			return
 		}
 
-		if update.CallbackQuery != nil {
-			cq := update.CallbackQuery
-			userID := cq.From.ID
-			user := getOrCreateUser(userID, cq.From.FirstName)
+		if strings.HasPrefix(data, "trainerspage_") {
+			var page int
+			pageStr, filter, _ := strings.Cut(strings.TrimPrefix(data, "trainerspage_"), "_")
+			fmt.Sscanf(pageStr, "%d", &page)
+			if filter == "" {
+				// Buttons from before the format filter existed.
+				filter = string(filterAll)
+			}
+			edit := telegram.NewEditMessageReplyMarkup(cq.Message.Chat.ID, cq.Message.MessageID, trainersPageKeyboard(user.IsActive(), page, trainerFilter(filter)))
+			_ = send(bot, edit)
+			return
+		}
 
-			data := cq.Data
-			_ = answerCallback(bot, cq.ID, "")
+		if strings.HasPrefix(data, "trainer_") {
+			idStr := strings.TrimPrefix(data, "trainer_")
+			var id int
+			fmt.Sscanf(idStr, "%d", &id)
+			tr, _ := getTrainerByID(id)
+			if tr == nil {
+				_ = replyError(bot, cq.Message.Chat.ID, "Тренер не найден")
+				return
+			}
+			touchRecentTrainer(userID, tr.ID)
+			_ = showTrainerCard(bot, cq.Message.Chat.ID, *tr, user.IsActive(), cq.Message.MessageID)
+			return
+		}
 
-			if data == "menu" {
-				m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Вас приветствует фитнес зал %s!", gymName))
-				m.ReplyMarkup = mainMenuKeyboard()
-				_ = send(bot, m)
-				continue
+		if strings.HasPrefix(data, "book_") {
+			if !user.IsActive() {
+				_ = replyError(bot, cq.Message.Chat.ID, "Чтобы записаться, сначала оплатите абонемент в разделе \"Прайс абонементов\".")
+				return
 			}
-			if data == "trainers" {
-				m := telegram.NewMessage(cq.Message.Chat.ID, "Наши тренеры (нажмите имя, чтобы узнать подробнее):")
-				m.ReplyMarkup = trainersInlineKeyboard(user.HasPaid)
-				_ = send(bot, m)
-				continue
+			idStr := strings.TrimPrefix(data, "book_")
+			var id int
+			fmt.Sscanf(idStr, "%d", &id)
+			tr, _ := getTrainerByID(id)
+			if tr == nil {
+				_ = replyError(bot, cq.Message.Chat.ID, "Тренер не найден")
+				return
+			}
+			if err := tr.bookable(); err != nil {
+				_ = replyError(bot, cq.Message.Chat.ID, "Не удалось записаться: "+err.Error())
+				return
 			}
+			text := fmt.Sprintf("Выберите время для тренера %s:", tr.Name)
+			if price := sessionPriceText(*tr); price != "" {
+				text = price + "\n\n" + text
+			}
+			if isBlackout(*tr, today()) {
+				text = fmt.Sprintf("Тренер %s сегодня не работает. Выберите другого тренера.", tr.Name)
+			}
+			m := telegram.NewMessage(cq.Message.Chat.ID, text)
+			m.ReplyMarkup = scheduleKeyboard(tr.ID)
+			_ = showMenu(bot, m, cq.Message.MessageID)
+			return
+		}
 
-			if strings.HasPrefix(data, "trainer_") {
-				idStr := strings.TrimPrefix(data, "trainer_")
-				var id int
-				fmt.Sscanf(idStr, "%d", &id)
-				tr, _ := getTrainerByID(id)
-				if tr == nil {
-					_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Тренер не найден"))
-					continue
-				}
-				text := fmt.Sprintf("%s\n\nОписание: %s\n\nДостижения:\n• %s", tr.Name, tr.Bio, strings.Join(tr.Achievements, "\n• "))
-				m := telegram.NewMessage(cq.Message.Chat.ID, text)
-				m.ReplyMarkup = trainerDetailsKeyboard(*tr, user.HasPaid)
-				_ = send(bot, m)
-				continue
+		if strings.HasPrefix(data, "slot_") {
+			parts := strings.SplitN(strings.TrimPrefix(data, "slot_"), "_", 2)
+			if len(parts) != 2 {
+				return
+			}
+			var trainerID int
+			fmt.Sscanf(parts[0], "%d", &trainerID)
+			slot := parts[1]
+
+			if !user.IsActive() {
+				alert = "Сначала оплатите абонемент."
+				return
 			}
 
-			if strings.HasPrefix(data, "book_") {
-				if !user.HasPaid {
-					_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Чтобы записаться, сначала оплатите абонемент в разделе \"Прайс абонементов\"."))
-					continue
+			if err := holdSlot(userID, trainerID, slot); err != nil {
+				refreshKeyboard(bot, cq.Message, scheduleKeyboard(trainerID))
+				if errors.Is(err, errSlotTaken) {
+					_ = send(bot, slotTakenMessage(cq.Message.Chat.ID, trainerID, slot))
+					return
 				}
-				idStr := strings.TrimPrefix(data, "book_")
-				var id int
-				fmt.Sscanf(idStr, "%d", &id)
-				tr, _ := getTrainerByID(id)
-				if tr == nil {
-					_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Тренер не найден"))
-					continue
+				alert = "Не удалось записаться: " + err.Error()
+				return
+			}
+			_ = saveState()
+
+			tr, _ := getTrainerByID(trainerID)
+			text := fmt.Sprintf("Тренер %s, время %s.\n⏳ У вас %s на подтверждение, иначе время снова станет свободным.", tr.Name, formatSessionFor(userID, tr.ID, today(), slot), holdTTLText())
+			m := telegram.NewMessage(cq.Message.Chat.ID, text)
+			m.ReplyMarkup = confirmHoldKeyboard(trainerID, slot)
+			if sent, err := sendSync(bot, m); err == nil {
+				setHoldPrompt(userID, cq.Message.Chat.ID, sent.MessageID)
+			}
+			return
+		}
+
+		if strings.HasPrefix(data, "multi_") {
+			var trainerID int
+			fmt.Sscanf(strings.TrimPrefix(data, "multi_"), "%d", &trainerID)
+			setConversation(userID, ConversationState{Step: stepMultiSelect, TrainerID: trainerID})
+			refreshKeyboard(bot, cq.Message, multiSelectKeyboard(trainerID, nil))
+			return
+		}
+
+		if strings.HasPrefix(data, "msel_") {
+			parts := strings.SplitN(strings.TrimPrefix(data, "msel_"), "_", 2)
+			if len(parts) != 2 {
+				return
+			}
+			var trainerID int
+			fmt.Sscanf(parts[0], "%d", &trainerID)
+			selected := toggleSelection(userID, trainerID, parts[1])
+			refreshKeyboard(bot, cq.Message, multiSelectKeyboard(trainerID, selected))
+			return
+		}
+
+		if strings.HasPrefix(data, "mbook_") {
+			var trainerID int
+			fmt.Sscanf(strings.TrimPrefix(data, "mbook_"), "%d", &trainerID)
+			if !user.IsActive() {
+				alert = "Сначала оплатите абонемент."
+				return
+			}
+			st, ok := getConversation(userID)
+			if !ok || st.Step != stepMultiSelect || st.TrainerID != trainerID || len(st.Selected) == 0 {
+				alert = "Выбор устарел, отметьте время заново."
+				refreshKeyboard(bot, cq.Message, multiSelectKeyboard(trainerID, nil))
+				return
+			}
+			booked, err := bookSlots(userID, trainerID, st.Selected)
+			if err != nil {
+				alert = "Не удалось записаться, ничего не забронировано. " + err.Error()
+				refreshKeyboard(bot, cq.Message, multiSelectKeyboard(trainerID, st.Selected))
+				return
+			}
+			clearConversation(userID)
+			metricBookings.Add(int64(len(booked)))
+			_ = saveState()
+			for _, b := range booked {
+				notifyStaffBooking(bot, b, "🆕 Новая запись")
+			}
+			bookings := userBookings(userID)
+			m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Записали вас на %s.\n\n%s", bookedSlotsText(booked), myBookingsText(bookings)))
+			m.ReplyMarkup = myBookingsKeyboard(bookings)
+			msgs := []telegram.Chattable{m}
+			for _, b := range booked {
+				if doc, ok := icsDocument(cq.Message.Chat.ID, b); ok {
+					msgs = append(msgs, doc)
 				}
-				m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Выберите время для тренера %s:", tr.Name))
-				m.ReplyMarkup = scheduleKeyboard(tr.ID)
+			}
+			_ = sendBatch(bot, cq.Message.Chat.ID, msgs...)
+			return
+		}
+
+		if strings.HasPrefix(data, "confirm_") {
+			parts := strings.SplitN(strings.TrimPrefix(data, "confirm_"), "_", 2)
+			if len(parts) != 2 {
+				return
+			}
+			var trainerID int
+			fmt.Sscanf(parts[0], "%d", &trainerID)
+			slot := parts[1]
+
+			// The subscription may have run out since the schedule was opened.
+			if !user.IsActive() {
+				releaseHold(userID)
+				_ = saveState()
+				m := telegram.NewMessage(cq.Message.Chat.ID, "Срок действия абонемента истёк. Продлите его, чтобы записаться:")
+				m.ReplyMarkup = pricingKeyboard()
 				_ = send(bot, m)
-				continue
+				return
 			}
 
-			if strings.HasPrefix(data, "slot_") {
-				parts := strings.SplitN(strings.TrimPrefix(data, "slot_"), "_", 2)
-				if len(parts) != 2 {
-					continue
+			b, err := confirmHold(userID, trainerID, slot)
+			if err != nil {
+				_ = saveState()
+				refreshKeyboard(bot, cq.Message, scheduleKeyboard(trainerID))
+				if errors.Is(err, errSlotTaken) {
+					_ = send(bot, slotTakenMessage(cq.Message.Chat.ID, trainerID, slot))
+					return
 				}
-				var trainerID int
-				fmt.Sscanf(parts[0], "%d", &trainerID)
-				slot := parts[1]
+				alert = "Не удалось записаться: " + err.Error()
+				return
+			}
+			metricBookings.Add(1)
+			_ = saveState()
+			notifyStaffBooking(bot, b, "🆕 Новая запись")
 
-				if !user.HasPaid {
-					_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Сначала оплатите абонемент."))
-					continue
-				}
+			confirm := fmt.Sprintf("Запись подтверждена! Тренер #%d, время %s.\nКод записи: %s — назовите его на ресепшене.", trainerID, formatSessionFor(userID, b.Trainer, b.Date, b.TimeSlot), b.Code)
+			tr, _ := getTrainerByID(trainerID)
+			m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Свободные слоты у %s обновлены:", tr.Name))
+			m.ReplyMarkup = scheduleKeyboard(tr.ID)
+
+			setConversation(userID, ConversationState{Step: stepBookingNote, BookingID: b.ID})
+			ask := telegram.NewMessage(cq.Message.Chat.ID, "Добавить комментарий к записи? Напишите цель тренировки или ограничения по здоровью одним сообщением.")
+			ask.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+				telegram.NewInlineKeyboardButtonData("Пропустить", "noteskip"),
+			))
+			cm := telegram.NewMessage(cq.Message.Chat.ID, confirm)
+			cm.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+				telegram.NewInlineKeyboardButtonData(fmt.Sprintf("🔁 Повторять еженедельно (%d нед.)", recurringWeeks), fmt.Sprintf("repeat_%d", b.ID)),
+			))
+			msgs := []telegram.Chattable{cm}
+			if doc, ok := icsDocument(cq.Message.Chat.ID, b); ok {
+				msgs = append(msgs, doc)
+			}
+			_ = sendBatch(bot, cq.Message.Chat.ID, append(msgs, m, ask)...)
+			return
+		}
+
+		if strings.HasPrefix(data, "repeat_") {
+			var bookingID int
+			fmt.Sscanf(strings.TrimPrefix(data, "repeat_"), "%d", &bookingID)
+			handleRepeatWeekly(bot, cq, bookingID)
+			return
+		}
+
+		if strings.HasPrefix(data, "rcancel_") {
+			var seriesID int
+			fmt.Sscanf(strings.TrimPrefix(data, "rcancel_"), "%d", &seriesID)
+			handleCancelSeries(bot, cq, seriesID)
+			return
+		}
+
+		if strings.HasPrefix(data, "offer_") || strings.HasPrefix(data, "offerno_") {
+			handleOfferCallback(bot, cq, user)
+			return
+		}
+
+		if strings.HasPrefix(data, "unhold_") {
+			var trainerID int
+			fmt.Sscanf(strings.TrimPrefix(data, "unhold_"), "%d", &trainerID)
+			releaseHold(userID)
+			_ = saveState()
+			m := telegram.NewMessage(cq.Message.Chat.ID, "Бронь снята. Выберите другое время:")
+			m.ReplyMarkup = scheduleKeyboard(trainerID)
+			_ = send(bot, m)
+			return
+		}
+
+		if data == "noteskip" {
+			clearConversation(userID)
+			_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Хорошо, без комментария."))
+			return
+		}
+
+		if strings.HasPrefix(data, "contact_") {
+			var id int
+			fmt.Sscanf(strings.TrimPrefix(data, "contact_"), "%d", &id)
+			tr, _ := getTrainerByID(id)
+			if tr == nil {
+				_ = replyError(bot, cq.Message.Chat.ID, "Тренер не найден")
+				return
+			}
+			if !user.IsActive() {
+				_ = replyError(bot, cq.Message.Chat.ID, "Написать тренеру могут только участники с оплаченным абонементом.")
+				return
+			}
+			if staffChat == 0 {
+				_ = replyError(bot, cq.Message.Chat.ID, "Связь с тренерами сейчас недоступна.")
+				return
+			}
+			setConversation(userID, ConversationState{Step: stepContactTrainer, TrainerID: tr.ID})
+			_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Напишите сообщение для тренера %s одним сообщением. Для отмены выберите любой пункт меню.", tr.Name)))
+			return
+		}
+
+		if strings.HasPrefix(data, "subscribe_") {
+			var id int
+			fmt.Sscanf(strings.TrimPrefix(data, "subscribe_"), "%d", &id)
+			if err := subscribeToTrainer(userID, id); err != nil {
+				_ = replyError(bot, cq.Message.Chat.ID, err.Error())
+				return
+			}
+			_ = saveState()
+			_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Готово! Мы сообщим, когда у тренера появится свободное время."))
+			return
+		}
+
+		if data == "mybookings" {
+			bookings := userBookings(userID)
+			m := telegram.NewMessage(cq.Message.Chat.ID, myBookingsText(bookings))
+			m.ReplyMarkup = myBookingsKeyboard(bookings)
+			_ = showMenu(bot, m, cq.Message.MessageID)
+			return
+		}
+
+		if strings.HasPrefix(data, "bcancel_") {
+			var bookingID int
+			fmt.Sscanf(strings.TrimPrefix(data, "bcancel_"), "%d", &bookingID)
+
+			b, notify, err := cancelBooking(userID, bookingID)
+			if err != nil {
+				_ = replyError(bot, cq.Message.Chat.ID, "Не удалось отменить: "+err.Error())
+				return
+			}
+			_ = saveState()
+
+			bookings := userBookings(userID)
+			m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Запись на %s отменена.\n\n%s", formatSessionFor(userID, b.Trainer, b.Date, b.TimeSlot), myBookingsText(bookings)))
+			m.ReplyMarkup = myBookingsKeyboard(bookings)
+			_ = showMenu(bot, m, cq.Message.MessageID)
+			notifySubscribers(bot, notify, b.Trainer, b.TimeSlot)
+			return
+		}
 
-				if err := bookSlot(userID, trainerID, slot); err != nil {
-					_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Не удалось записаться: "+err.Error()))
-					continue
+		if data == "cancellist" {
+			_ = showMenu(bot, cancelPickerMessage(cq.Message.Chat.ID, userID, ""), cq.Message.MessageID)
+			return
+		}
+
+		if strings.HasPrefix(data, "ccancel_") {
+			b, ok := userBookingByCode(userID, strings.TrimPrefix(data, "ccancel_"))
+			if !ok {
+				_ = showMenu(bot, cancelPickerMessage(cq.Message.Chat.ID, userID, "Запись не найдена."), cq.Message.MessageID)
+				return
+			}
+			m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Отменить запись %s на %s?", b.Code, formatSessionFor(userID, b.Trainer, b.Date, b.TimeSlot)))
+			m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+				telegram.NewInlineKeyboardButtonData("✅ Да, отменить", "ccancelok_"+b.Code),
+				telegram.NewInlineKeyboardButtonData("⬅️ Назад", "cancellist"),
+			))
+			_ = showMenu(bot, m, cq.Message.MessageID)
+			return
+		}
+
+		if strings.HasPrefix(data, "ccancelok_") {
+			found, ok := userBookingByCode(userID, strings.TrimPrefix(data, "ccancelok_"))
+			if !ok {
+				_ = showMenu(bot, cancelPickerMessage(cq.Message.Chat.ID, userID, "Запись не найдена."), cq.Message.MessageID)
+				return
+			}
+			b, notify, err := cancelBooking(userID, found.ID)
+			if err != nil {
+				_ = replyError(bot, cq.Message.Chat.ID, "Не удалось отменить: "+err.Error())
+				return
+			}
+			_ = saveState()
+			note := fmt.Sprintf("Запись %s на %s отменена.", b.Code, formatSessionFor(userID, b.Trainer, b.Date, b.TimeSlot))
+			_ = showMenu(bot, cancelPickerMessage(cq.Message.Chat.ID, userID, note), cq.Message.MessageID)
+			notifySubscribers(bot, notify, b.Trainer, b.TimeSlot)
+			return
+		}
+
+		if strings.HasPrefix(data, "bmove_") {
+			var bookingID int
+			fmt.Sscanf(strings.TrimPrefix(data, "bmove_"), "%d", &bookingID)
+			var found *Booking
+			for _, b := range userBookings(userID) {
+				if b.ID == bookingID {
+					found = &b
+					break
 				}
-				_ = saveState()
+			}
+			if found == nil {
+				_ = replyError(bot, cq.Message.Chat.ID, "Запись не найдена.")
+				return
+			}
+			m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Текущая запись: %s. Выберите новое время:", found.TimeSlot))
+			m.ReplyMarkup = rescheduleKeyboard(*found)
+			_ = showMenu(bot, m, cq.Message.MessageID)
+			return
+		}
 
-				confirm := fmt.Sprintf("Запись подтверждена! Тренер #%d, время %s.", trainerID, slot)
-				_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, confirm))
+		if strings.HasPrefix(data, "bmoveto_") {
+			parts := strings.SplitN(strings.TrimPrefix(data, "bmoveto_"), "_", 2)
+			if len(parts) != 2 {
+				return
+			}
+			var bookingID int
+			fmt.Sscanf(parts[0], "%d", &bookingID)
+			slot := parts[1]
 
-				tr, _ := getTrainerByID(trainerID)
-				m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Свободные слоты у %s обновлены:", tr.Name))
-				m.ReplyMarkup = scheduleKeyboard(tr.ID)
-				_ = send(bot, m)
-				continue
+			b, notify, err := moveBooking(userID, bookingID, slot)
+			if err != nil {
+				alert = "Не удалось перенести: " + err.Error()
+				for _, cur := range userBookings(userID) {
+					if cur.ID == bookingID {
+						refreshKeyboard(bot, cq.Message, rescheduleKeyboard(cur))
+					}
+				}
+				return
 			}
+			_ = saveState()
 
-			if strings.HasPrefix(data, "pay_") {
-				stateMu.Lock()
-				state.Users[userID].HasPaid = true
-				stateMu.Unlock()
-				_ = saveState()
+			bookings := userBookings(userID)
+			m := telegram.NewMessage(cq.Message.Chat.ID, fmt.Sprintf("Запись перенесена с %s на %s.\n\n%s", b.TimeSlot, formatSessionFor(userID, b.Trainer, b.Date, slot), myBookingsText(bookings)))
+			m.ReplyMarkup = myBookingsKeyboard(bookings)
+			_ = send(bot, m)
+			notifySubscribers(bot, notify, b.Trainer, b.TimeSlot)
+			return
+		}
 
-				_ = send(bot, telegram.NewMessage(cq.Message.Chat.ID, "Операция прошла успешно!"))
-				m := telegram.NewMessage(cq.Message.Chat.ID, "Теперь вы можете записаться к тренеру в разделе \"Тренеры\":")
-				m.ReplyMarkup = trainersInlineKeyboard(true)
-				_ = send(bot, m)
-				continue
+		if strings.HasPrefix(data, "pay_") {
+			paid, err := setUserPaid(userID, strings.TrimPrefix(data, "pay_"))
+			if err != nil {
+				if errors.Is(err, errNoCapacity) {
+					_ = replyError(bot, cq.Message.Chat.ID, "Запись временно закрыта, мест нет. Попробуйте позже.")
+					return
+				}
+				_ = replyError(bot, cq.Message.Chat.ID, "Не удалось оплатить: "+err.Error())
+				return
+			}
+			metricPayments.Add(1)
+			price, discount := applyDiscount(userID)
+			recordPayment(userID, paid.Tier, price, "")
+			_ = saveState()
+
+			done := "Операция прошла успешно!"
+			if t, ok := findTier(paid.Tier); ok {
+				done += fmt.Sprintf(" Абонемент %s на %s, действует до %s.", t.Name, daysText(t.DurationDays), formatDate(time.Unix(paid.PaidUntil, 0).In(gymLoc), userLang(userID)))
 			}
+			if discount > 0 {
+				done += fmt.Sprintf(" Списано %s со скидкой %d%%.", formatMoney(price), discount)
+			}
+			m := telegram.NewMessage(cq.Message.Chat.ID, "Теперь вы можете записаться к тренеру в разделе \"Тренеры\":")
+			m.ReplyMarkup = trainersInlineKeyboard(true)
+			_ = sendBatch(bot, cq.Message.Chat.ID, telegram.NewMessage(cq.Message.Chat.ID, done), m)
+			return
+		}
+
+		// "noop" buttons, like the page counter, do nothing on purpose.
+		if data != "noop" {
+			log.Printf("callback %q is listed in knownCallbacks but not handled", data)
+		}
+	}
+}
+
+// knownCallbacks lists the callback data the bot handles: exact values, and
+// prefixes ending in "_". Anything else comes from a keyboard sent by an
+// older version of the bot.
+var knownCallbacks = []string{
+	"menu", "trainers", "mybookings", "noteskip", "noop",
+	"onboard_", "trainer_", "trainerspage_", "book_", "slot_", "confirm_",
+	"unhold_", "repeat_", "rcancel_", "demo", "demo_", "demoslot_", "democonfirm_", "cancellist", "ccancel_", "ccancelok_", "multi_", "msel_", "mbook_",
+	"subscribe_", "contact_", "bcancel_", "bmove_", "bmoveto_", "pay_", "offer_", "offerno_",
+}
+
+func isKnownCallback(data string) bool {
+	for _, k := range knownCallbacks {
+		if data == k || strings.HasSuffix(k, "_") && strings.HasPrefix(data, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageFile picks how to send an image: by the cached file_id when there is
+// one, otherwise from the URL or local file src.
+func imageFile(src, fileID string) (telegram.RequestFileData, error) {
+	switch {
+	case fileID != "":
+		return telegram.FileID(fileID), nil
+	case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+		return telegram.FileURL(src), nil
+	default:
+		if _, err := os.Stat(src); err != nil {
+			return nil, err
 		}
+		return telegram.FilePath(src), nil
 	}
 }
 
-func send(bot *telegram.BotAPI, msg telegram.Chattable) error {
-	_, err := bot.Send(msg)
+// sendWelcomeImage sends the WELCOME_IMAGE banner (a local path or an URL) if
+// one is configured. After the first upload Telegram's file_id is reused.
+func sendWelcomeImage(bot Sender, chatID int64) {
+	if welcomeImage == "" {
+		return
+	}
+	stateMu.Lock()
+	fileID := ""
+	if state.WelcomeImageSource == welcomeImage {
+		fileID = state.WelcomeImageFileID
+	}
+	stateMu.Unlock()
+
+	file, err := imageFile(welcomeImage, fileID)
 	if err != nil {
-		log.Printf("send error: %v", err)
+		log.Printf("welcome image: %v", err)
+		return
 	}
-	return err
+
+	sent, err := sendSync(bot, telegram.NewPhoto(chatID, file))
+	if err != nil {
+		log.Printf("send welcome image: %v", err)
+		return
+	}
+	if fileID != "" || len(sent.Photo) == 0 {
+		return
+	}
+	largest := sent.Photo[len(sent.Photo)-1]
+	stateMu.Lock()
+	state.WelcomeImageSource = welcomeImage
+	state.WelcomeImageFileID = largest.FileID
+	stateMu.Unlock()
+	_ = saveState()
+}
+
+// replyError sends a user-facing error with the main menu attached, so the
+// user always has a way forward.
+func replyError(bot Sender, chatID int64, text string) error {
+	log.Printf("error reply to %d: %s", chatID, text)
+	m := telegram.NewMessage(chatID, "⚠️ "+text)
+	m.ReplyMarkup = mainMenuKeyboard(userLang(chatID))
+	return send(bot, m)
+}
+
+// send queues msg for delivery and returns without waiting for Telegram.
+// The error only reports a message that could not be queued.
+func send(bot Sender, msg telegram.Chattable) error {
+	return dispatch(outJob{bot: bot, chatID: chatOf(msg), msgs: []telegram.Chattable{msg}}, false).err
+}
+
+// sendSync is send for callers that need the sent message or must know
+// whether delivery succeeded. It still goes through the chat's queue, so
+// ordering with earlier sends is kept.
+func sendSync(bot Sender, msg telegram.Chattable) (telegram.Message, error) {
+	res := dispatch(outJob{bot: bot, chatID: chatOf(msg), msgs: []telegram.Chattable{msg}}, true)
+	return res.msg, res.err
+}
+
+const (
+	maxBatchMessages  = 5
+	maxBatchFailures  = 2
+	batchSendInterval = 50 * time.Millisecond
+)
+
+// sendBatch queues msgs to chatID as one job that goes out in order. It never
+// sends more than maxBatchMessages per call and gives up after
+// maxBatchFailures failures in a row, so a misbehaving handler can't flood a
+// chat.
+func sendBatch(bot Sender, chatID int64, msgs ...telegram.Chattable) error {
+	if len(msgs) > maxBatchMessages {
+		log.Printf("sendBatch: chat %d: dropping %d of %d messages", chatID, len(msgs)-maxBatchMessages, len(msgs))
+		msgs = msgs[:maxBatchMessages]
+	}
+	return dispatch(outJob{bot: bot, chatID: chatID, msgs: msgs}, false).err
+}
+
+// maxMessageLen is Telegram's limit on message text, in characters.
+const maxMessageLen = 4096
+
+// sendLong sends text to chatID, split on line boundaries into messages
+// under maxMessageLen. The parts go out in order as one job through
+// sendBatch; text that needs more than maxBatchMessages parts is cut short
+// with a note saying so.
+func sendLong(bot Sender, chatID int64, text string) error {
+	parts := splitMessage(text, maxMessageLen)
+	if len(parts) > maxBatchMessages {
+		total := len(parts)
+		parts = append(parts[:maxBatchMessages-1], fmt.Sprintf("… Вывод обрезан: показано %d из %d сообщений.", maxBatchMessages-1, total))
+	}
+	msgs := make([]telegram.Chattable, len(parts))
+	for i, p := range parts {
+		msgs[i] = telegram.NewMessage(chatID, p)
+	}
+	return sendBatch(bot, chatID, msgs...)
+}
+
+// splitMessage cuts text into chunks of at most limit characters, breaking
+// between lines. A single line longer than limit is cut mid-line.
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	var cur strings.Builder
+	curLen, started := 0, false
+	flush := func() {
+		if started {
+			chunks = append(chunks, cur.String())
+		}
+		cur.Reset()
+		curLen, started = 0, false
+	}
+	for _, line := range strings.Split(text, "\n") {
+		r := []rune(line)
+		for len(r) > limit {
+			flush()
+			chunks = append(chunks, string(r[:limit]))
+			r = r[limit:]
+		}
+		if started && curLen+1+len(r) > limit {
+			flush()
+		}
+		if started {
+			cur.WriteByte('\n')
+			curLen++
+		}
+		cur.WriteString(string(r))
+		curLen += len(r)
+		started = true
+	}
+	flush()
+	return chunks
 }
 
-func answerCallback(bot *telegram.BotAPI, id string, text string) error {
+// answerCallback stops the button spinner. With showAlert the text is shown
+// as a popup the user has to dismiss rather than a short toast.
+func answerCallback(bot Sender, id string, text string, showAlert bool) error {
 	cb := telegram.NewCallback(id, text)
+	cb.ShowAlert = showAlert
 	_, err := bot.Request(cb)
 	return err
 }
+
+// isBookingCallback reports whether data is a callback that books or moves
+// a slot.
+func isBookingCallback(data string) bool {
+	for _, p := range []string{"slot_", "confirm_", "bmoveto_", "mbook_", "offer_"} {
+		if strings.HasPrefix(data, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshKeyboard replaces the inline keyboard of msg in place.
+func refreshKeyboard(bot Sender, msg *telegram.Message, kb telegram.InlineKeyboardMarkup) {
+	if msg == nil {
+		return
+	}
+	_ = send(bot, telegram.NewEditMessageReplyMarkup(msg.Chat.ID, msg.MessageID, kb))
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxAuditEntries bounds the audit log kept in the state file; the oldest
+// entries are dropped first.
+const maxAuditEntries = 500
+
+// AdminAction is one state change made through an admin command.
+type AdminAction struct {
+	AdminID int64  `json:"admin_id"`
+	Action  string `json:"action"`
+	Target  string `json:"target"`
+	At      int64  `json:"at"`
+}
+
+func recordAdminAction(adminID int64, action, target string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	state.AuditLog = append(state.AuditLog, AdminAction{AdminID: adminID, Action: action, Target: target, At: now().Unix()})
+	if n := len(state.AuditLog) - maxAuditEntries; n > 0 {
+		state.AuditLog = append([]AdminAction(nil), state.AuditLog[n:]...)
+	}
+}
+
+// requireAdmin replies with a refusal and returns false for non-admins.
+func requireAdmin(bot Sender, msg *telegram.Message) bool {
+	if isAdmin(msg.From.ID) {
+		return true
+	}
+	_ = replyError(bot, msg.Chat.ID, "Команда доступна только администраторам.")
+	return false
+}
+
+// requireStaff is requireAdmin for staff features: it accepts staff and
+// admins, and any command sent in the staff chat.
+func requireStaff(bot Sender, msg *telegram.Message) bool {
+	if staffChat != 0 && msg.Chat.ID == staffChat || hasRole(msg.From.ID, roleStaff) {
+		return true
+	}
+	_ = replyError(bot, msg.Chat.ID, "Команда доступна только сотрудникам.")
+	return false
+}
+
+func subscriptionStatus(u User) string {
+	if !u.HasPaid {
+		return "абонемент не оплачен"
+	}
+	status := "абонемент активен"
+	if !u.IsActive() {
+		status = "абонемент истёк"
+	}
+	if t, ok := findTier(u.Tier); ok {
+		status += ", тариф " + t.Name
+	}
+	if u.PaidUntil != 0 {
+		status += ", до " + time.Unix(u.PaidUntil, 0).In(gymLoc).Format(dateLayout)
+	}
+	return status
+}
+
+func handleBlackout(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /blackout <id тренера> <ГГГГ-ММ-ДД>")
+		return
+	}
+	trainerID, err := strconv.Atoi(args[0])
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id тренера.")
+		return
+	}
+	day, err := time.ParseInLocation(dateLayout, args[1], gymLoc)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверная дата, ожидается формат ГГГГ-ММ-ДД.")
+		return
+	}
+	date := args[1]
+
+	dropped, err := addBlackout(trainerID, date)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось добавить выходной: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "blackout", fmt.Sprintf("trainer %d %s", trainerID, date))
+	_ = saveState()
+
+	tr, _ := getTrainerByID(trainerID)
+	for _, b := range dropped {
+		text := fmt.Sprintf("К сожалению, тренер %s не работает %s. Ваша запись на %s отменена.\nВыберите другое время или тренера:", tr.Name, formatDate(day, userLang(b.UserID)), formatSessionFor(b.UserID, b.Trainer, b.Date, b.TimeSlot))
+		m := telegram.NewMessage(chatIDFor(b.UserID), text)
+		m.ReplyMarkup = trainersInlineKeyboard(true)
+		_ = notify(bot, m)
+	}
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Выходной %s для тренера %s добавлен. Отменено записей: %d.", date, tr.Name, len(dropped))))
+}
+
+func handleResetUser(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 1 {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /resetuser <id пользователя>")
+		return
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id пользователя.")
+		return
+	}
+	u, err := resetUserPaid(userID)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось сбросить абонемент: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "resetuser", fmt.Sprintf("user %d", userID))
+	_ = saveState()
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Пользователь %s (id %d): %s.", u.Name, u.ID, subscriptionStatus(u))))
+}
+
+func handleGrant(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 {
+		codes := make([]string, len(tiers))
+		for i, t := range tiers {
+			codes[i] = t.Code
+		}
+		_ = replyError(bot, msg.Chat.ID, "Использование: /grant <id пользователя> <"+strings.Join(codes, "|")+">")
+		return
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id пользователя.")
+		return
+	}
+	u, err := setUserPaid(userID, strings.ToLower(args[1]))
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось выдать абонемент: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "grant", fmt.Sprintf("user %d %s", userID, u.Tier))
+	_ = saveState()
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Пользователь %s (id %d): %s.", u.Name, u.ID, subscriptionStatus(u))))
+}
+
+const (
+	maxBioLen         = 500
+	maxAchievementLen = 200
+	maxSpecialties    = 10
+	maxSpecialtyLen   = 30
+)
+
+// parseSpecialties reads a comma-separated tag list, dropping blanks and
+// duplicates. "off" clears the list.
+func parseSpecialties(text string) ([]string, error) {
+	if text == "off" {
+		return nil, nil
+	}
+	var tags []string
+	for _, f := range strings.Split(text, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || slices.ContainsFunc(tags, func(t string) bool { return strings.EqualFold(t, f) }) {
+			continue
+		}
+		if utf8.RuneCountInString(f) > maxSpecialtyLen {
+			return nil, fmt.Errorf("специализация «%s» слишком длинная, максимум %d символов", f, maxSpecialtyLen)
+		}
+		tags = append(tags, f)
+	}
+	if len(tags) > maxSpecialties {
+		return nil, fmt.Errorf("не больше %d специализаций", maxSpecialties)
+	}
+	return tags, nil
+}
+
+// splitArgs splits s into at most n whitespace-separated fields; the last
+// field keeps the rest of the string verbatim.
+func splitArgs(s string, n int) []string {
+	var out []string
+	s = strings.TrimSpace(s)
+	for len(out) < n-1 && s != "" {
+		i := strings.IndexFunc(s, unicode.IsSpace)
+		if i == -1 {
+			break
+		}
+		out = append(out, s[:i])
+		s = strings.TrimSpace(s[i:])
+	}
+	if s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+func handleEditTrainer(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	const usage = "Использование:\n/edittrainer <id> bio <текст>\n/edittrainer <id> addach <достижение>\n/edittrainer <id> maxday <число, 0 — без ограничений>\n/edittrainer <id> premium <доплата, 0 — без доплаты>\n/edittrainer <id> session <длительность в минутах>\n/edittrainer <id> photo <ссылка на фото или off>\n/edittrainer <id> group on|off\n/edittrainer <id> tags <бокс, ОФП или off>"
+	args := splitArgs(msg.CommandArguments(), 3)
+	if len(args) != 3 {
+		_ = replyError(bot, msg.Chat.ID, usage)
+		return
+	}
+	trainerID, err := strconv.Atoi(args[0])
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id тренера.")
+		return
+	}
+	text := args[2]
+
+	var edit func(t *Trainer)
+	var done string
+	switch args[1] {
+	case "bio":
+		if n := utf8.RuneCountInString(text); n > maxBioLen {
+			_ = replyError(bot, msg.Chat.ID, fmt.Sprintf("Описание слишком длинное: %d символов, максимум %d.", n, maxBioLen))
+			return
+		}
+		edit = func(t *Trainer) { t.Bio = text }
+		done = "Описание обновлено"
+	case "addach":
+		if n := utf8.RuneCountInString(text); n > maxAchievementLen {
+			_ = replyError(bot, msg.Chat.ID, fmt.Sprintf("Достижение слишком длинное: %d символов, максимум %d.", n, maxAchievementLen))
+			return
+		}
+		edit = func(t *Trainer) { t.Achievements = append(t.Achievements, text) }
+		done = "Достижение добавлено"
+	case "maxday":
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			_ = replyError(bot, msg.Chat.ID, "Лимит должен быть неотрицательным числом.")
+			return
+		}
+		edit = func(t *Trainer) { t.MaxPerDay = n }
+		done = "Лимит тренировок в день обновлён"
+	case "premium":
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 0 {
+			_ = replyError(bot, msg.Chat.ID, "Доплата должна быть неотрицательным числом.")
+			return
+		}
+		edit = func(t *Trainer) { t.PriceModifier = n }
+		done = "Доплата обновлена"
+	case "session":
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 15 || n > 240 {
+			_ = replyError(bot, msg.Chat.ID, "Длительность должна быть от 15 до 240 минут.")
+			return
+		}
+		edit = func(t *Trainer) { t.SessionMinutes = n }
+		done = "Длительность занятия обновлена"
+	case "photo":
+		if text == "off" {
+			text = ""
+		} else if !strings.HasPrefix(text, "http://") && !strings.HasPrefix(text, "https://") {
+			_ = replyError(bot, msg.Chat.ID, "Укажите ссылку на фото, начинающуюся с http:// или https://, или off.")
+			return
+		}
+		edit = func(t *Trainer) { t.PhotoURL, t.PhotoFileID = text, "" }
+		done = "Фото обновлено"
+	case "tags":
+		tags, err := parseSpecialties(text)
+		if err != nil {
+			_ = replyError(bot, msg.Chat.ID, err.Error())
+			return
+		}
+		edit = func(t *Trainer) { t.Specialties = tags }
+		done = "Специализации обновлены"
+	case "group":
+		if text != "on" && text != "off" {
+			_ = replyError(bot, msg.Chat.ID, "Укажите on — групповые занятия или off — персональные.")
+			return
+		}
+		group := text == "on"
+		edit = func(t *Trainer) { t.GroupClass = group }
+		done = "Формат занятий обновлён"
+	default:
+		_ = replyError(bot, msg.Chat.ID, usage)
+		return
+	}
+
+	if err := updateTrainer(trainerID, edit); err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось изменить тренера: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "edittrainer "+args[1], fmt.Sprintf("trainer %d", trainerID))
+	_ = saveState()
+	tr, _ := getTrainerByID(trainerID)
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("%s: %s.", done, tr.Name)))
+}
+
+// handleDelTrainer hides a trainer from menus and stops new bookings. The
+// record itself stays so existing bookings still resolve the trainer's name.
+func handleDelTrainer(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 1 {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /deltrainer <id тренера>")
+		return
+	}
+	trainerID, err := strconv.Atoi(args[0])
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id тренера.")
+		return
+	}
+	if err := updateTrainer(trainerID, func(t *Trainer) { t.Active = false }); err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось удалить тренера: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "deltrainer", fmt.Sprintf("trainer %d", trainerID))
+	_ = saveState()
+	tr, _ := getTrainerByID(trainerID)
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Тренер %s скрыт из меню и больше не принимает записи. Существующие записи сохранены.", tr.Name)))
+}
+
+// auditLogPageSize is how many recent entries /auditlog shows.
+const auditLogPageSize = 20
+
+func handleAuditLog(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	entries := snapshot().AuditLog
+	if len(entries) == 0 {
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Журнал действий пуст."))
+		return
+	}
+	if len(entries) > auditLogPageSize {
+		entries = entries[len(entries)-auditLogPageSize:]
+	}
+	var sb strings.Builder
+	sb.WriteString("Последние действия администраторов:\n")
+	for i := len(entries) - 1; i >= 0; i-- {
+		a := entries[i]
+		at := time.Unix(a.At, 0).In(gymLoc).Format("02.01.2006 15:04")
+		fmt.Fprintf(&sb, "\n%s — admin %d: %s (%s)", at, a.AdminID, a.Action, a.Target)
+	}
+	_ = sendLong(bot, msg.Chat.ID, sb.String())
+}
+
+func isBanned(userID int64) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return state.Banned[userID]
+}
+
+func unbanUser(userID int64) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	delete(state.Banned, userID)
+}
+
+// parseUserArg reads the single user id argument of /ban and /unban.
+func parseUserArg(bot Sender, msg *telegram.Message) (int64, bool) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 1 {
+		_ = replyError(bot, msg.Chat.ID, fmt.Sprintf("Использование: /%s <id пользователя>", msg.Command()))
+		return 0, false
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id пользователя.")
+		return 0, false
+	}
+	return userID, true
+}
+
+// banCancellation is a booking dropped by a ban and the users subscribed to
+// the trainer's free slots, who are told the slot has opened up.
+type banCancellation struct {
+	booking Booking
+	notify  []int64
+}
+
+// banUser bans the user and cancels their hold and upcoming bookings in
+// one critical section, so nothing booked in between survives the ban.
+func banUser(userID int64) []banCancellation {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if state.Banned == nil {
+		state.Banned = map[int64]bool{}
+	}
+	state.Banned[userID] = true
+	releaseHoldLocked(userID)
+
+	date := today()
+	var ids []int
+	for _, b := range state.Bookings {
+		if b.UserID == userID && b.Date >= date {
+			ids = append(ids, b.ID)
+		}
+	}
+	var res []banCancellation
+	for _, id := range ids {
+		b, notify, err := cancelBookingLocked(userID, id)
+		if err != nil {
+			continue
+		}
+		res = append(res, banCancellation{b, notify})
+	}
+	return res
+}
+
+// handleBan blocks a user and cancels their bookings so the slots go back
+// to everyone else.
+func handleBan(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	userID, ok := parseUserArg(bot, msg)
+	if !ok {
+		return
+	}
+	if isAdmin(userID) {
+		_ = replyError(bot, msg.Chat.ID, "Нельзя заблокировать администратора.")
+		return
+	}
+	cancelled := banUser(userID)
+	for _, c := range cancelled {
+		notifyStaffBooking(bot, c.booking, "❌ Запись отменена (блокировка)")
+		notifySubscribers(bot, c.notify, c.booking.Trainer, c.booking.TimeSlot)
+	}
+	recordAdminAction(msg.From.ID, "ban", fmt.Sprintf("user %d", userID))
+	_ = saveState()
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Пользователь %d заблокирован. Отменено записей: %d.", userID, len(cancelled))))
+}
+
+func handleUnban(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	userID, ok := parseUserArg(bot, msg)
+	if !ok {
+		return
+	}
+	unbanUser(userID)
+	recordAdminAction(msg.From.ID, "unban", fmt.Sprintf("user %d", userID))
+	_ = saveState()
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Пользователь %d разблокирован.", userID)))
+}
+
+// handleReload re-reads the state file, e.g. after it was edited by hand.
+// Every update is saved as soon as it is handled, so the only changes lost
+// are ones made to the in-memory state in the last instant by background
+// sweepers.
+func handleReload(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	s, err := reloadState()
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось перечитать состояние, текущее сохранено без изменений: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "reload", statePath)
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Состояние перечитано с диска. Пользователей: %d, тренеров: %d, записей: %d.", len(s.Users), len(s.Trainers), len(s.Bookings))))
+}
+
+// handleTrainerStatus marks a trainer away or back, for absences too short
+// or unplanned for a blackout.
+func handleTrainerStatus(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 || (args[1] != "away" && args[1] != "active") {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /trainerstatus <id тренера> away|active")
+		return
+	}
+	trainerID, err := strconv.Atoi(args[0])
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id тренера.")
+		return
+	}
+	away := args[1] == "away"
+	if err := updateTrainer(trainerID, func(t *Trainer) { t.Away = away }); err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось изменить статус: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "trainerstatus "+args[1], fmt.Sprintf("trainer %d", trainerID))
+	_ = saveState()
+	tr, _ := getTrainerByID(trainerID)
+	status := "снова принимает записи"
+	if away {
+		status = "временно недоступен"
+	}
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Тренер %s %s.", tr.Name, status)))
+}
+
+// maxBookingLimit bounds /setlimit so a typo can't open a trainer's whole
+// week to one user.
+const maxBookingLimit = 50
+
+// handleSetLimit overrides a user's per-trainer booking cap; "default"
+// removes the override.
+func handleSetLimit(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /setlimit <id пользователя> <число|default>")
+		return
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id пользователя.")
+		return
+	}
+	var limit *int
+	if args[1] != "default" {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > maxBookingLimit {
+			_ = replyError(bot, msg.Chat.ID, fmt.Sprintf("Лимит должен быть числом от 1 до %d или default.", maxBookingLimit))
+			return
+		}
+		limit = &n
+	}
+	stateMu.Lock()
+	u, ok := state.Users[userID]
+	var user User
+	if ok {
+		u.BookingLimitOverride = limit
+		user = *u
+	}
+	stateMu.Unlock()
+	if !ok {
+		_ = replyError(bot, msg.Chat.ID, "Пользователь не найден.")
+		return
+	}
+	recordAdminAction(msg.From.ID, "setlimit "+args[1], fmt.Sprintf("user %d", userID))
+	_ = saveState()
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Лимит записей для %s (id %d): %d.", user.Name, userID, user.bookingLimit())))
+}
+
+// handleDumpState answers /dumpstate [trainers|users|bookings|user <id>] with
+// the current state, or the requested part of it, as a JSON file. A file
+// rather than text, since a real state runs to far more messages than a
+// chat should get at once.
+func handleDumpState(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	s := snapshot()
+	var part any = s
+	name := "state"
+	args := strings.Fields(msg.CommandArguments())
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && args[0] == "trainers":
+		part, name = s.Trainers, "trainers"
+	case len(args) == 1 && args[0] == "users":
+		part, name = s.Users, "users"
+	case len(args) == 1 && args[0] == "bookings":
+		part, name = s.Bookings, "bookings"
+	case len(args) == 2 && args[0] == "user":
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		u, ok := s.Users[id]
+		if err != nil || !ok {
+			_ = replyError(bot, msg.Chat.ID, "Пользователь не найден.")
+			return
+		}
+		part, name = u, fmt.Sprintf("user-%d", id)
+	default:
+		_ = replyError(bot, msg.Chat.ID, "Использование: /dumpstate [trainers|users|bookings|user <id>]")
+		return
+	}
+	b, err := json.MarshalIndent(part, "", "  ")
+	if err != nil {
+		log.Printf("dumpstate: %v", err)
+		_ = replyError(bot, msg.Chat.ID, "Не удалось выгрузить состояние.")
+		return
+	}
+	doc := telegram.NewDocument(msg.Chat.ID, telegram.FileBytes{Name: name + ".json", Bytes: b})
+	doc.Caption = fmt.Sprintf("Состояние на %s", now().In(gymLoc).Format("02.01.2006 15:04"))
+	_ = send(bot, doc)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestBanCancelsBookingsAndNotifiesWaitlist(t *testing.T) {
+	setupState(t)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, HasPaid: true}
+	stateMu.Unlock()
+	if _, err := bookSlot(7, 1, "10:00"); err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	if err := subscribeToTrainer(8, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelled := banUser(7)
+	if len(cancelled) != 1 {
+		t.Fatalf("cancelled %d bookings, want 1", len(cancelled))
+	}
+	if !slices.Equal(cancelled[0].notify, []int64{8}) {
+		t.Errorf("notify = %v, want the waitlisted user 8", cancelled[0].notify)
+	}
+	if !slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("10:00 was not put back on sale")
+	}
+	if len(userBookings(7)) != 0 {
+		t.Error("banned user still has bookings")
+	}
+	if _, err := bookSlot(7, 1, "10:00"); err == nil {
+		t.Error("banned user could book again")
+	}
+}
+
+func TestBannedUserUpdatesAreShortCircuited(t *testing.T) {
+	setupState(t)
+	banUser(7)
+
+	bot := &fakeSender{}
+	handleUpdate(bot, textUpdate(7, "/start"))
+	handleUpdate(bot, callbackUpdate(7, "slot_1_10:00"))
+
+	if got := bot.texts(); len(got) != 1 || got[0] != "Вы заблокированы. Обратитесь к администратору зала." {
+		t.Errorf("messages = %q, want only the ban notice", got)
+	}
+	answers := bot.callbackAnswers()
+	if len(answers) != 1 || answers[0].Text != "Вы заблокированы." {
+		t.Errorf("callback answers = %+v, want the ban notice", answers)
+	}
+	if _, ok := snapshot().Users[7]; ok {
+		t.Error("/start registered a banned user")
+	}
+	if !slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("banned user held a slot")
+	}
+}
+
+// asAdmin lists id in ADMIN_IDS for the duration of the test.
+func asAdmin(t *testing.T, id int64) {
+	t.Helper()
+	adminIDs[id] = true
+	t.Cleanup(func() { delete(adminIDs, id) })
+}
+
+func TestResetUserAndGrant(t *testing.T) {
+	setupState(t)
+	asAdmin(t, 1000)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Name: "Test"}
+	stateMu.Unlock()
+
+	for _, c := range []struct {
+		from  int64
+		text  string
+		reply string
+	}{
+		{7, "/resetuser 7", "только администраторам"},
+		{1000, "/resetuser", "Использование: /resetuser"},
+		{1000, "/resetuser abc", "Неверный id пользователя."},
+		{1000, "/resetuser 999", "Не удалось сбросить абонемент: " + errUserNotFound.Error()},
+		{1000, "/grant 999 gold", "Не удалось выдать абонемент: " + errUserNotFound.Error()},
+		{1000, "/grant 7 platinum", "неизвестный тариф"},
+		{1000, "/grant 7", "Использование: /grant <id пользователя> <gold|silver|bronze|student>"},
+	} {
+		bot := &fakeSender{}
+		handleUpdate(bot, textUpdate(c.from, c.text))
+		if !bot.sentContaining(c.reply) {
+			t.Errorf("%s: replies %q, want one containing %q", c.text, bot.texts(), c.reply)
+		}
+	}
+	if u := snapshot().Users[7]; u.HasPaid {
+		t.Fatal("a refused command changed the user")
+	}
+
+	bot := &fakeSender{}
+	handleUpdate(bot, textUpdate(1000, "/grant 7 GOLD"))
+	u := snapshot().Users[7]
+	if !u.HasPaid || u.Tier != "gold" || u.PaidUntil != now().AddDate(0, 0, 30).Unix() {
+		t.Errorf("after /grant: %+v", u)
+	}
+	handleUpdate(bot, textUpdate(1000, "/resetuser 7"))
+	u = snapshot().Users[7]
+	if u.HasPaid || u.Tier != "" || u.PaidUntil != 0 {
+		t.Errorf("after /resetuser: %+v", u)
+	}
+	if got := bot.texts(); len(got) != 2 || !strings.HasPrefix(got[1], "Пользователь Test (id 7)") {
+		t.Errorf("replies = %q", got)
+	}
+}
+
+func TestDeletedTrainerKeepsBookings(t *testing.T) {
+	setupState(t)
+	asAdmin(t, 1000)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Name: "Test", HasPaid: true}
+	stateMu.Unlock()
+	if _, err := bookSlot(7, 1, "10:00"); err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	tr, _ := getTrainerByID(1)
+
+	bot := &fakeSender{}
+	handleUpdate(bot, textUpdate(1000, "/deltrainer 1"))
+	if len(userBookings(7)) != 1 {
+		t.Fatal("deleting the trainer dropped the booking")
+	}
+
+	bot = &fakeSender{}
+	handleUpdate(bot, callbackUpdate(7, "mybookings"))
+	if !bot.sentContaining(tr.Name + " (больше не работает)") {
+		t.Errorf("my bookings = %q, want the trainer marked as gone", bot.texts())
+	}
+	bot = &fakeSender{}
+	handleUpdate(bot, callbackUpdate(7, "trainer_1"))
+	if !bot.sentContaining("Тренер больше не принимает записи.") {
+		t.Errorf("trainer details = %q, want the inactive notice", bot.texts())
+	}
+	if _, err := bookSlot(8, 1, "11:00"); !errors.Is(err, errTrainerInactive) {
+		t.Errorf("booking a deleted trainer: got %v, want errTrainerInactive", err)
+	}
+	for _, tr := range activeTrainers(snapshot().Trainers) {
+		if tr.ID == 1 {
+			t.Error("deleted trainer is still listed")
+		}
+	}
+}
+
+func TestBookingLimitOverride(t *testing.T) {
+	setupState(t)
+	asAdmin(t, 1000)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Name: "Test", HasPaid: true}
+	stateMu.Unlock()
+	slots := trainerSlots(t, 1)
+
+	book := func(n int) int {
+		t.Helper()
+		booked := 0
+		for _, s := range slots {
+			if booked == n {
+				break
+			}
+			if _, err := bookSlot(7, 1, s); err != nil {
+				break
+			}
+			booked++
+		}
+		return booked
+	}
+	reset := func() {
+		stateMu.Lock()
+		state.Bookings = nil
+		state.Trainers = defaultTrainers()
+		stateMu.Unlock()
+	}
+
+	if got := book(10); got != maxBookingsPerTrainer {
+		t.Errorf("without an override booked %d, want %d", got, maxBookingsPerTrainer)
+	}
+
+	bot := &fakeSender{}
+	for _, c := range []struct {
+		arg  string
+		want int
+	}{
+		{"1", 1},
+		{"5", 5},
+		{"default", maxBookingsPerTrainer},
+	} {
+		reset()
+		handleUpdate(bot, textUpdate(1000, "/setlimit 7 "+c.arg))
+		if got := book(10); got != c.want {
+			t.Errorf("/setlimit %s: booked %d, want %d", c.arg, got, c.want)
+		}
+	}
+
+	bot = &fakeSender{}
+	handleUpdate(bot, textUpdate(1000, "/setlimit 7 0"))
+	if !bot.sentContaining("Лимит должен быть числом") || snapshot().Users[7].BookingLimitOverride != nil {
+		t.Errorf("/setlimit 7 0: replies %q", bot.texts())
+	}
+}
+
+func TestDumpStateSendsOneFile(t *testing.T) {
+	setupState(t)
+	asAdmin(t, 1000)
+	stateMu.Lock()
+	for i := range 2000 {
+		id := int64(10000 + i)
+		state.Users[id] = &User{ID: id, Name: strings.Repeat("x", 50)}
+	}
+	stateMu.Unlock()
+
+	for _, c := range []struct{ args, file string }{
+		{"", "state.json"},
+		{" users", "users.json"},
+		{" user 10001", "user-10001.json"},
+	} {
+		bot := &fakeSender{}
+		handleUpdate(bot, textUpdate(1000, "/dumpstate"+c.args))
+		if len(bot.sent) != 1 {
+			t.Fatalf("/dumpstate%s sent %d messages, want one file", c.args, len(bot.sent))
+		}
+		doc, ok := bot.sent[0].(telegram.DocumentConfig)
+		if !ok {
+			t.Fatalf("/dumpstate%s sent %T, want a document", c.args, bot.sent[0])
+		}
+		f := doc.File.(telegram.FileBytes)
+		if f.Name != c.file || !json.Valid(f.Bytes) {
+			t.Errorf("/dumpstate%s: file %s, valid JSON %v", c.args, f.Name, json.Valid(f.Bytes))
+		}
+	}
+}
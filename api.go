@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The admin API lets a web dashboard do what admins do in chat. It is
+// started only when ADMIN_API_ADDR is set, and every request must carry
+// "Authorization: Bearer <ADMIN_API_TOKEN>". Changes are recorded in the
+// audit log with admin ID 0.
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("admin api: write response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// requireToken rejects requests without the API token, and changes while
+// the bot is in read-only mode.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		if r.Method != http.MethodGet && readOnly.Load() {
+			writeError(w, http.StatusServiceUnavailable, "state cannot be saved, changes are disabled")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func apiListUsers(w http.ResponseWriter, r *http.Request) {
+	s := snapshot()
+	users := make([]User, 0, len(s.Users))
+	for _, u := range s.Users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	writeJSON(w, http.StatusOK, users)
+}
+
+func apiListBookings(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, snapshot().Bookings)
+}
+
+func apiListTrainers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, snapshot().Trainers)
+}
+
+func apiAddTrainer(w http.ResponseWriter, r *http.Request) {
+	var t Trainer
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err := dec.Decode(&t); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	t, err := addTrainer(t)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	recordAdminAction(0, "api addtrainer", fmt.Sprintf("trainer %d", t.ID))
+	_ = saveState()
+	writeJSON(w, http.StatusCreated, t)
+}
+
+// apiDeleteTrainer hides the trainer like /deltrainer does.
+func apiDeleteTrainer(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid trainer id")
+		return
+	}
+	if err := updateTrainer(id, func(t *Trainer) { t.Active = false }); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	recordAdminAction(0, "api deltrainer", fmt.Sprintf("trainer %d", id))
+	_ = saveState()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiGrant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+	var req struct {
+		Tier string `json:"tier"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	u, err := setUserPaid(id, strings.ToLower(req.Tier))
+	switch {
+	case errors.Is(err, errNoCapacity):
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, errUserNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	recordAdminAction(0, "api grant", fmt.Sprintf("user %d %s", id, u.Tier))
+	_ = saveState()
+	writeJSON(w, http.StatusOK, u)
+}
+
+// serveAdminAPI serves the admin API on addr. It refuses to start without a
+// token rather than expose user data.
+func serveAdminAPI(addr, token string) {
+	if token == "" {
+		log.Printf("admin api: ADMIN_API_TOKEN is not set, not starting")
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/users", requireToken(token, apiListUsers))
+	mux.HandleFunc("GET /api/bookings", requireToken(token, apiListBookings))
+	mux.HandleFunc("GET /api/trainers", requireToken(token, apiListTrainers))
+	mux.HandleFunc("POST /api/trainers", requireToken(token, apiAddTrainer))
+	mux.HandleFunc("DELETE /api/trainers/{id}", requireToken(token, apiDeleteTrainer))
+	mux.HandleFunc("POST /api/users/{id}/grant", requireToken(token, apiGrant))
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+	log.Printf("admin api listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil {
+		log.Printf("admin api server: %v", err)
+	}
+}
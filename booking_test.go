@@ -0,0 +1,462 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// trainerSlots returns a copy of the trainer's free slots for today.
+func trainerSlots(t *testing.T, id int) []string {
+	t.Helper()
+	tr, _ := getTrainerByID(id)
+	if tr == nil {
+		t.Fatalf("trainer %d not found", id)
+	}
+	return tr.Slots
+}
+
+func TestBlackoutOfFutureDateKeepsTodaysSlots(t *testing.T) {
+	setupState(t)
+	if _, err := bookSlot(1, 1, "09:00"); err != nil {
+		t.Fatalf("book today: %v", err)
+	}
+	tomorrow := now().AddDate(0, 0, 1).Format(dateLayout)
+	stateMu.Lock()
+	state.Bookings = append(state.Bookings, Booking{ID: 99, UserID: 2, Trainer: 1, TimeSlot: "09:00", Date: tomorrow})
+	stateMu.Unlock()
+	before := trainerSlots(t, 1)
+
+	dropped, err := addBlackout(1, tomorrow)
+	if err != nil {
+		t.Fatalf("addBlackout: %v", err)
+	}
+	if len(dropped) != 1 || dropped[0].ID != 99 {
+		t.Fatalf("dropped = %+v, want only the booking for %s", dropped, tomorrow)
+	}
+	if after := trainerSlots(t, 1); !slices.Equal(after, before) {
+		t.Errorf("today's slots changed from %v to %v", before, after)
+	}
+}
+
+func TestConcurrentBookingsOfOneSlot(t *testing.T) {
+	setupState(t)
+	const n = 50
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = bookSlot(int64(100+i), 1, "10:00")
+		}()
+	}
+	wg.Wait()
+
+	ok := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			ok++
+		case !errors.Is(err, errSlotTaken):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d bookings succeeded, want exactly 1", ok)
+	}
+	if slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("10:00 is still on sale")
+	}
+	s := snapshot()
+	if len(s.Bookings) != 1 {
+		t.Errorf("%d bookings recorded, want 1", len(s.Bookings))
+	}
+}
+
+func TestConcurrentBookingsOfDistinctSlots(t *testing.T) {
+	setupState(t)
+	type pick struct {
+		trainer int
+		slot    string
+	}
+	var picks []pick
+	for _, tr := range snapshot().Trainers {
+		for _, s := range tr.Slots {
+			picks = append(picks, pick{tr.ID, s})
+		}
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, len(picks))
+	for i, p := range picks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = bookSlot(int64(100+i), p.trainer, p.slot)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("book trainer %d %s: %v", picks[i].trainer, picks[i].slot, err)
+		}
+	}
+	s := snapshot()
+	if len(s.Bookings) != len(picks) {
+		t.Errorf("%d bookings recorded, want %d", len(s.Bookings), len(picks))
+	}
+	for _, tr := range s.Trainers {
+		if len(tr.Slots) != 0 {
+			t.Errorf("trainer %d still has free slots %v", tr.ID, tr.Slots)
+		}
+	}
+	codes := map[string]bool{}
+	for _, b := range s.Bookings {
+		if codes[b.Code] {
+			t.Errorf("booking code %s issued twice", b.Code)
+		}
+		codes[b.Code] = true
+	}
+}
+
+func TestEarliestAvailableIgnoresPastBookings(t *testing.T) {
+	setupState(t)
+	yesterday := now().AddDate(0, 0, -1).Format(dateLayout)
+	stateMu.Lock()
+	state.Bookings = append(state.Bookings, Booking{ID: 1, UserID: 5, Trainer: 3, TimeSlot: "10:00", Date: yesterday})
+	stateMu.Unlock()
+
+	// At 06:00 the earliest slot of all is trainer 4's 07:00.
+	id, slot, ok := earliestAvailableFor(5, now())
+	if !ok || id != 4 || slot != "07:00" {
+		t.Errorf("with a past booking: got trainer %d %s %v, want trainer 4 07:00", id, slot, ok)
+	}
+
+	stateMu.Lock()
+	state.Bookings[0].Date = today()
+	stateMu.Unlock()
+	id, _, ok = earliestAvailableFor(5, now())
+	if !ok || id != 3 {
+		t.Errorf("with an upcoming booking: got trainer %d %v, want trainer 3", id, ok)
+	}
+}
+
+func TestBlackoutDayHasNoBookableSlots(t *testing.T) {
+	setupState(t)
+	b, err := bookSlot(1, 1, "09:00")
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	dropped, err := addBlackout(1, today())
+	if err != nil {
+		t.Fatalf("addBlackout: %v", err)
+	}
+	if len(dropped) != 1 || dropped[0].ID != b.ID {
+		t.Errorf("dropped = %+v, want the 09:00 booking", dropped)
+	}
+
+	if _, err := bookSlot(2, 1, "10:00"); err == nil {
+		t.Error("booked a slot on a blackout day")
+	}
+	if err := holdSlot(2, 1, "10:00"); err == nil {
+		t.Error("held a slot on a blackout day")
+	}
+	if got := nearestSlots(1, "10:00", 3); len(got) != 0 {
+		t.Errorf("nearestSlots = %v, want none", got)
+	}
+	tr, _ := getTrainerByID(1)
+	if id, _, ok := earliestSlot([]Trainer{*tr}, now()); ok {
+		t.Errorf("earliestSlot offered trainer %d", id)
+	}
+	if _, err := addBlackout(42, today()); err == nil {
+		t.Error("blackout for an unknown trainer succeeded")
+	}
+}
+
+func TestSubscriptionExpiringBeforeConfirm(t *testing.T) {
+	setupState(t)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Name: "Test", HasPaid: true, Tier: "gold", PaidUntil: now().Add(time.Minute).Unix()}
+	stateMu.Unlock()
+
+	bot := &fakeSender{}
+	handleUpdate(bot, callbackUpdate(7, "slot_1_10:00"))
+	if slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Fatal("choosing the slot did not hold it")
+	}
+
+	setNow(t, testClock.Add(2*time.Minute))
+	handleUpdate(bot, callbackUpdate(7, "confirm_1_10:00"))
+
+	if !bot.sentContaining("Срок действия абонемента истёк") {
+		t.Errorf("replies = %q, want the expiry notice", bot.texts())
+	}
+	if len(userBookings(7)) != 0 {
+		t.Error("booking confirmed after the subscription expired")
+	}
+	if !slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("the hold was not released")
+	}
+}
+
+func TestTrainerDailyCap(t *testing.T) {
+	setupState(t)
+	if err := updateTrainer(1, func(tr *Trainer) { tr.MaxPerDay = 2 }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bookSlot(101, 1, "08:00"); err != nil {
+		t.Fatalf("first booking: %v", err)
+	}
+	if err := holdSlot(102, 1, "09:00"); err != nil {
+		t.Fatalf("hold: %v", err)
+	}
+	// The hold counts towards the cap.
+	if _, err := bookSlot(103, 1, "10:00"); err == nil {
+		t.Error("third session booked over a cap of 2")
+	}
+	if err := holdSlot(103, 1, "10:00"); err == nil {
+		t.Error("third session held over a cap of 2")
+	}
+	if _, err := bookSlot(105, 2, "10:00"); err != nil {
+		t.Errorf("the cap applied to another trainer: %v", err)
+	}
+
+	releaseHold(102)
+	if _, err := bookSlot(103, 1, "10:00"); err != nil {
+		t.Errorf("booking after the hold was released: %v", err)
+	}
+
+	if err := updateTrainer(1, func(tr *Trainer) { tr.MaxPerDay = 0 }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bookSlot(104, 1, "11:00"); err != nil {
+		t.Errorf("zero cap still limited bookings: %v", err)
+	}
+}
+
+func TestOverlapAcrossTrainers(t *testing.T) {
+	setupState(t)
+	if _, err := bookSlot(7, 2, "10:00"); err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	tomorrow := now().AddDate(0, 0, 1).Format(dateLayout)
+
+	stateMu.Lock()
+	sameTime := checkUserOverlap(7, today(), "10:00", 0)
+	otherTime := checkUserOverlap(7, today(), "11:00", 0)
+	otherDay := checkUserOverlap(7, tomorrow, "10:00", 0)
+	otherUser := checkUserOverlap(8, today(), "10:00", 0)
+	stateMu.Unlock()
+
+	if !errors.Is(sameTime, errSlotOverlap) {
+		t.Errorf("same time with another trainer: got %v, want errSlotOverlap", sameTime)
+	}
+	for name, err := range map[string]error{"other time": otherTime, "other day": otherDay, "other user": otherUser} {
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := bookSlot(7, 1, "10:00"); err == nil {
+		t.Error("booked trainer 1 at the time already booked with trainer 2")
+	}
+	if !slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("refused booking took trainer 1's slot")
+	}
+}
+
+func TestClosestSlots(t *testing.T) {
+	slots := []string{"08:00", "09:00", "10:00", "11:00", "12:00", "14:00", "15:00"}
+	for _, c := range []struct {
+		around, current string
+		n               int
+		want            []string
+	}{
+		{"11:00", "06:00", 3, []string{"10:00", "12:00", "09:00"}},
+		{"12:00", "06:00", 4, []string{"11:00", "10:00", "14:00", "09:00"}},
+		{"13:00", "06:00", 2, []string{"12:00", "14:00"}},
+		{"10:00", "09:30", 3, []string{"11:00", "12:00", "14:00"}},
+		{"08:00", "06:00", 1, []string{"09:00"}},
+		{"15:00", "15:00", 3, nil},
+		{"bad", "06:00", 3, nil},
+	} {
+		got := closestSlots(slots, c.around, c.current, c.n)
+		if len(got)+len(c.want) > 0 && !slices.Equal(got, c.want) {
+			t.Errorf("closestSlots(around %s, after %s, %d) = %v, want %v", c.around, c.current, c.n, got, c.want)
+		}
+	}
+}
+
+func TestNearestSlotsSkipStartedSessions(t *testing.T) {
+	setupState(t)
+	setNow(t, testClock.Add(4*time.Hour)) // 10:00
+	got := nearestSlots(1, "11:00", 3)
+	if !slices.Equal(got, []string{"12:00", "14:00", "15:00"}) {
+		t.Errorf("nearestSlots at 10:00 = %v", got)
+	}
+}
+
+func TestMoveBookingFollowsBookingRules(t *testing.T) {
+	setup := func(t *testing.T) Booking {
+		setupState(t)
+		b, err := bookSlot(7, 1, "10:00")
+		if err != nil {
+			t.Fatalf("book: %v", err)
+		}
+		return b
+	}
+
+	t.Run("blackout", func(t *testing.T) {
+		b := setup(t)
+		if err := updateTrainer(1, func(tr *Trainer) { tr.Blackouts = append(tr.Blackouts, today()) }); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := moveBooking(7, b.ID, "11:00"); err == nil {
+			t.Error("moved a booking onto a blackout day")
+		}
+	})
+
+	t.Run("overlap", func(t *testing.T) {
+		b := setup(t)
+		stateMu.Lock()
+		state.Bookings = append(state.Bookings, Booking{ID: 50, UserID: 7, Trainer: 2, TimeSlot: "11:00", Date: today()})
+		stateMu.Unlock()
+		if _, _, err := moveBooking(7, b.ID, "11:00"); !errors.Is(err, errSlotOverlap) {
+			t.Errorf("move onto another booking's time: got %v, want errSlotOverlap", err)
+		}
+		if _, _, err := moveBooking(7, b.ID, "12:00"); err != nil {
+			t.Errorf("move to a free time: %v", err)
+		}
+	})
+
+	t.Run("daily cap", func(t *testing.T) {
+		b := setup(t)
+		if _, err := bookSlot(8, 1, "12:00"); err != nil {
+			t.Fatal(err)
+		}
+		if err := updateTrainer(1, func(tr *Trainer) { tr.MaxPerDay = 2 }); err != nil {
+			t.Fatal(err)
+		}
+		// The moved booking doesn't count against the cap.
+		if _, _, err := moveBooking(7, b.ID, "11:00"); err != nil {
+			t.Fatalf("move within the cap: %v", err)
+		}
+		if err := updateTrainer(1, func(tr *Trainer) { tr.MaxPerDay = 1 }); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := moveBooking(7, b.ID, "14:00"); err == nil {
+			t.Error("moved a booking of a trainer over the daily cap")
+		}
+		if got := userBookings(7); len(got) != 1 || got[0].TimeSlot != "11:00" {
+			t.Errorf("bookings after the refused move = %+v", got)
+		}
+		if !slices.Contains(trainerSlots(t, 1), "14:00") {
+			t.Error("refused move took 14:00")
+		}
+	})
+}
+
+// sentTo returns the text of every message sent or edited in chatID.
+func sentTo(bot *fakeSender, chatID int64) []string {
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	var res []string
+	for _, c := range bot.sent {
+		switch m := c.(type) {
+		case telegram.MessageConfig:
+			if m.ChatID == chatID {
+				res = append(res, m.Text)
+			}
+		case telegram.EditMessageTextConfig:
+			if m.ChatID == chatID {
+				res = append(res, m.Text)
+			}
+		}
+	}
+	return res
+}
+
+func TestFreedSlotOfferPassesDownTheQueue(t *testing.T) {
+	setupState(t)
+	stateMu.Lock()
+	for _, id := range []int64{200, 201, 202, 203} {
+		state.Users[id] = &User{ID: id, Name: "Test", HasPaid: true, Tier: "gold", PaidUntil: now().AddDate(0, 0, 30).Unix()}
+	}
+	stateMu.Unlock()
+	b, err := bookSlot(200, 1, "10:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int64{201, 202, 203} {
+		if err := subscribeToTrainer(id, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bot := &fakeSender{}
+	handleUpdate(bot, callbackUpdate(200, "ccancelok_"+b.Code))
+	if slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Fatal("the freed slot went on sale instead of to the first subscriber")
+	}
+	if got := sentTo(bot, 201); len(got) != 1 || !strings.Contains(got[0], "10:00") {
+		t.Fatalf("first subscriber got %q, want the offer", got)
+	}
+	if len(sentTo(bot, 202)) != 0 {
+		t.Error("the second subscriber was told before the first one's offer ran out")
+	}
+
+	// The first subscriber lets the offer run out.
+	setNow(t, testClock.Add(offerTTL))
+	expireHolds(bot)
+	if got := sentTo(bot, 201); len(got) != 2 || !strings.Contains(got[1], "Предложение истекло") {
+		t.Errorf("first subscriber got %q, want the offer marked as expired", got)
+	}
+	if got := sentTo(bot, 202); len(got) != 1 || !strings.Contains(got[0], "10:00") {
+		t.Fatalf("second subscriber got %q, want the offer", got)
+	}
+	handleUpdate(bot, callbackUpdate(201, "offer_1_10:00"))
+	if len(upcomingUserBookings(201)) != 0 {
+		t.Error("the expired offer was still accepted")
+	}
+
+	// The second declines, the third books.
+	handleUpdate(bot, callbackUpdate(202, "offerno_1_10:00"))
+	if got := sentTo(bot, 203); len(got) != 1 {
+		t.Fatalf("third subscriber got %q, want the offer", got)
+	}
+	handleUpdate(bot, callbackUpdate(203, "offer_1_10:00"))
+	if got := upcomingUserBookings(203); len(got) != 1 || got[0].TimeSlot != "10:00" {
+		t.Errorf("third subscriber's bookings = %+v, want 10:00", got)
+	}
+}
+
+func TestUnclaimedOfferGoesBackOnSale(t *testing.T) {
+	setupState(t)
+	if err := subscribeToTrainer(201, 1); err != nil {
+		t.Fatal(err)
+	}
+	b, err := bookSlot(200, 1, "10:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, notify, err := cancelBooking(200, b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot := &fakeSender{}
+	notifySubscribers(bot, notify, 1, "10:00")
+
+	setNow(t, testClock.Add(offerTTL))
+	expireHolds(bot)
+	if !slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("the slot stayed off sale after the last offer ran out")
+	}
+	if len(snapshot().Holds) != 0 {
+		t.Errorf("holds left after the queue ran out: %+v", snapshot().Holds)
+	}
+}
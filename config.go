@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// configProblems collects what loadConfig found wrong with the environment.
+// validateConfig reports them all at once so a bad deployment fails at
+// startup with the full list instead of running on partial defaults.
+var configProblems []string
+
+func configProblem(format string, args ...any) {
+	configProblems = append(configProblems, fmt.Sprintf(format, args...))
+}
+
+// validateConfig checks the loaded configuration and returns every problem
+// found, one per line, or nil.
+func validateConfig() error {
+	problems := append([]string(nil), configProblems...)
+	seen := make(map[string]bool, len(tiers))
+	for _, t := range tiers {
+		if t.Price <= 0 {
+			problems = append(problems, fmt.Sprintf("tier %q: price must be positive, got %d", t.Code, t.Price))
+		}
+		if t.DurationDays <= 0 {
+			problems = append(problems, fmt.Sprintf("tier %q: duration must be positive, got %d days", t.Code, t.DurationDays))
+		}
+		if seen[t.Code] {
+			problems = append(problems, fmt.Sprintf("tier %q: duplicate code", t.Code))
+		}
+		seen[t.Code] = true
+	}
+	if os.Getenv("ADMIN_API_ADDR") != "" && os.Getenv("ADMIN_API_TOKEN") == "" {
+		problems = append(problems, "ADMIN_API_ADDR: set without ADMIN_API_TOKEN")
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+}
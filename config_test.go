@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateToken(t *testing.T) {
+	for _, tok := range []string{
+		"123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw",
+		"1:x",
+	} {
+		if err := validateToken(tok); err != nil {
+			t.Errorf("validateToken(%q) = %v, want nil", tok, err)
+		}
+	}
+	for _, tok := range []string{
+		"",
+		"123456789",
+		"123456789:",
+		":secret",
+		"bot123:secret",
+		"123456789:secret with space",
+		"123456789:secret\n",
+		" 123456789:secret",
+	} {
+		if err := validateToken(tok); err == nil {
+			t.Errorf("validateToken(%q) accepted a malformed token", tok)
+		}
+	}
+}
+
+func TestValidateConfigListsEveryProblem(t *testing.T) {
+	defer func(p []string, ts []Tier) { configProblems, tiers = p, ts }(configProblems, tiers)
+	configProblems = nil
+	if err := validateConfig(); err != nil {
+		t.Fatalf("default configuration rejected: %v", err)
+	}
+
+	t.Setenv("SLOT_RESET_AT", "25:00")
+	t.Setenv("NOSHOW_LIMIT", "-1")
+	t.Setenv("INLINE_MODE", "yes")
+	t.Setenv("ADMIN_API_ADDR", ":8081")
+	t.Setenv("ADMIN_API_TOKEN", "")
+	loadRolloverConfig()
+	loadNoShowConfig()
+	loadInlineConfig()
+	tiers = []Tier{
+		{Code: "gold", Price: 25000, DurationDays: 30},
+		{Code: "free", Price: 0, DurationDays: 30},
+		{Code: "gold", Price: 20000, DurationDays: 0},
+	}
+
+	err := validateConfig()
+	if err == nil {
+		t.Fatal("invalid configuration accepted")
+	}
+	for _, want := range []string{
+		"SLOT_RESET_AT",
+		"NOSHOW_LIMIT",
+		"INLINE_MODE",
+		`tier "free": price must be positive`,
+		`tier "gold": duration must be positive`,
+		`tier "gold": duplicate code`,
+		"ADMIN_API_ADDR: set without ADMIN_API_TOKEN",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// conversationTimeout is how long a pending step waits for the user's next
+// message before it is forgotten.
+const conversationTimeout = 10 * time.Minute
+
+type ConversationStep string
+
+const (
+	stepNone           ConversationStep = ""
+	stepContactTrainer ConversationStep = "contact_trainer"
+	stepBookingNote    ConversationStep = "booking_note"
+	stepMultiSelect    ConversationStep = "multi_select"
+	stepSupport        ConversationStep = "support"
+)
+
+// ConversationState is what the bot expects from a user's next message in a
+// multi-step flow. Only the fields relevant to Step are set.
+type ConversationState struct {
+	Step      ConversationStep
+	TrainerID int
+	BookingID int
+	Selected  []string
+	Since     time.Time
+}
+
+var (
+	conversations   = map[int64]ConversationState{}
+	conversationsMu sync.Mutex
+)
+
+func setConversation(userID int64, st ConversationState) {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+	st.Since = now()
+	conversations[userID] = st
+}
+
+// getConversation returns the user's pending step. Stale steps are cleared
+// and reported as absent.
+func getConversation(userID int64) (ConversationState, bool) {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+	st, ok := conversations[userID]
+	if !ok {
+		return ConversationState{}, false
+	}
+	if st.Step == stepNone || now().Sub(st.Since) > conversationTimeout {
+		delete(conversations, userID)
+		return ConversationState{}, false
+	}
+	return st, true
+}
+
+func clearConversation(userID int64) {
+	conversationsMu.Lock()
+	defer conversationsMu.Unlock()
+	delete(conversations, userID)
+}
+
+// handleConversation feeds msg to the user's pending step. It reports whether
+// the message was consumed; if not, normal routing continues.
+func handleConversation(bot Sender, msg *telegram.Message, name string, st ConversationState) bool {
+	switch st.Step {
+	case stepContactTrainer:
+		clearConversation(msg.From.ID)
+		handleContactMessage(bot, msg, name, st.TrainerID)
+		return true
+	case stepBookingNote:
+		clearConversation(msg.From.ID)
+		handleBookingNote(bot, msg, st.BookingID)
+		return true
+	case stepSupport:
+		clearConversation(msg.From.ID)
+		handleSupportMessage(bot, msg, name)
+		return true
+	}
+	return false
+}
+
+// sweepConversations drops stale steps and unanswered support tickets every
+// interval so abandoned flows don't pile up in memory.
+func sweepConversations(interval time.Duration) {
+	for range time.Tick(interval) {
+		conversationsMu.Lock()
+		for id, st := range conversations {
+			if now().Sub(st.Since) > conversationTimeout {
+				delete(conversations, id)
+			}
+		}
+		conversationsMu.Unlock()
+		pruneTickets()
+		pruneChatPacing()
+	}
+}
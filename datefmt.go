@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// monthNames are the month names per interface language, in the form used
+// after a day number.
+var monthNames = map[string][12]string{
+	"ru": {"января", "февраля", "марта", "апреля", "мая", "июня", "июля", "августа", "сентября", "октября", "ноября", "декабря"},
+	"kk": {"қаңтар", "ақпан", "наурыз", "сәуір", "мамыр", "маусым", "шілде", "тамыз", "қыркүйек", "қазан", "қараша", "желтоқсан"},
+	"en": {"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+}
+
+// formatDate writes t's date out in lang: "12 марта", "12 наурыз",
+// "March 12". The year is added when it isn't the current one. Languages
+// without a month table get the ISO date.
+func formatDate(t time.Time, lang string) string {
+	months, ok := monthNames[lang]
+	if !ok {
+		return t.Format(dateLayout)
+	}
+	month := months[t.Month()-1]
+	withYear := t.Year() != now().In(t.Location()).Year()
+	if lang == "en" {
+		if withYear {
+			return fmt.Sprintf("%s %d, %d", month, t.Day(), t.Year())
+		}
+		return fmt.Sprintf("%s %d", month, t.Day())
+	}
+	if withYear {
+		return fmt.Sprintf("%d %s %d", t.Day(), month, t.Year())
+	}
+	return fmt.Sprintf("%d %s", t.Day(), month)
+}
+
+// userLang is the user's interface language, Russian if unknown.
+func userLang(userID int64) string {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if u, ok := state.Users[userID]; ok && u.Lang != "" {
+		return u.Lang
+	}
+	return "ru"
+}
+
+// formatSessionFor is formatSession for a user: the date is written out in
+// their language, e.g. "15 октября 15:00–16:00 (UTC+05:00)".
+func formatSessionFor(userID int64, trainerID int, date, clock string) string {
+	t, err := time.ParseInLocation(dateLayout+" 15:04", date+" "+clock, gymLoc)
+	if err != nil {
+		return formatSession(trainerID, date, clock)
+	}
+	times := clock
+	if tr, _ := getTrainerByID(trainerID); tr != nil {
+		times = slotRange(*tr, clock)
+	}
+	return formatDate(t, userLang(userID)) + " " + times + " (UTC" + t.Format("-07:00") + ")"
+}
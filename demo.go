@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The demo lets users without a subscription walk through booking: trainer,
+// schedule, confirmation. Every step is carried in callback data, so nothing
+// is held or booked and state is never touched.
+
+const demoMark = "🧪 ДЕМО · "
+
+func demoTrainersMessage(chatID int64) telegram.MessageConfig {
+	rows := [][]telegram.InlineKeyboardButton{}
+	for _, t := range bookableTrainers(snapshot().Trainers) {
+		rows = append(rows, telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData(trainerIcon(t)+t.Name, fmt.Sprintf("demo_%d", t.ID)),
+		))
+	}
+	rows = append(rows, telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData("⬅️ В меню", "menu")))
+	m := telegram.NewMessage(chatID, demoMark+"Так выглядит запись к тренеру. Выберите тренера — ничего не будет забронировано.")
+	m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(rows...)
+	return m
+}
+
+func handleDemo(bot Sender, msg *telegram.Message) {
+	_ = showMenu(bot, demoTrainersMessage(msg.Chat.ID), 0)
+}
+
+// handleDemoCallback handles the demo_, demoslot_ and democonfirm_ steps.
+func handleDemoCallback(bot Sender, cq *telegram.CallbackQuery, data string) {
+	chatID, fromID := cq.Message.Chat.ID, cq.Message.MessageID
+	if data == "demo" {
+		_ = showMenu(bot, demoTrainersMessage(chatID), fromID)
+		return
+	}
+	step, rest, _ := strings.Cut(data, "_")
+	var trainerID int
+	fmt.Sscanf(rest, "%d", &trainerID)
+	_, slot, _ := strings.Cut(rest, "_")
+	tr, _ := getTrainerByID(trainerID)
+	if tr == nil || tr.bookable() != nil || step != "demo" && !slices.Contains(buildSlots(tr.Schedule), slot) {
+		_ = showMenu(bot, demoTrainersMessage(chatID), fromID)
+		return
+	}
+	var m telegram.MessageConfig
+	switch step {
+	case "demo":
+		m = telegram.NewMessage(chatID, demoMark+fmt.Sprintf("Выберите время для тренера %s:", tr.Name))
+		m.ReplyMarkup = slotsKeyboard(tr.ID, func(s string) string {
+			return fmt.Sprintf("demoslot_%d_%s", tr.ID, s)
+		}, "demo")
+	case "demoslot":
+		m = telegram.NewMessage(chatID, demoMark+fmt.Sprintf("Тренер %s, время %s. Подтвердить запись?", tr.Name, slotRange(*tr, slot)))
+		m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData("✅ Подтвердить", fmt.Sprintf("democonfirm_%d_%s", tr.ID, slot)),
+			telegram.NewInlineKeyboardButtonData("⬅️ Назад", fmt.Sprintf("demo_%d", tr.ID)),
+		))
+	default:
+		m = telegram.NewMessage(chatID, demoMark+fmt.Sprintf("Здесь запись к тренеру %s на %s была бы подтверждена.\n\nЭто демо. Оформите абонемент, чтобы записаться по-настоящему.", tr.Name, slotRange(*tr, slot)))
+		m.ReplyMarkup = pricingKeyboard()
+	}
+	_ = showMenu(bot, m, fromID)
+}
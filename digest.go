@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	// The weekly digest goes out on digestDay at digestAt (minutes after
+	// midnight, gym time). Set with DIGEST_AT="mon 09:00", or "off".
+	digestEnabled = true
+	digestDay     = time.Monday
+	digestAt      = 9 * 60
+	// digestChat receives the digest; it defaults to the staff chat and can
+	// be changed with DIGEST_CHAT_ID.
+	digestChat int64
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+}
+
+func loadDigestConfig() {
+	digestChat = staffChat
+	if v := os.Getenv("DIGEST_CHAT_ID"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			configProblem("DIGEST_CHAT_ID: invalid value %q", v)
+		} else {
+			digestChat = id
+		}
+	}
+	v := strings.ToLower(strings.TrimSpace(os.Getenv("DIGEST_AT")))
+	if v == "" {
+		return
+	}
+	if v == "off" {
+		digestEnabled = false
+		return
+	}
+	day, clock, _ := strings.Cut(v, " ")
+	wd, ok := weekdayNames[day]
+	m, err := parseClock(strings.TrimSpace(clock))
+	if !ok || err != nil {
+		configProblem(`DIGEST_AT: expected e.g. "mon 09:00" or "off", got %q`, v)
+		return
+	}
+	digestDay, digestAt = wd, m
+}
+
+// Stats summarizes activity between From and To.
+type Stats struct {
+	From, To   time.Time
+	NewMembers int
+	Bookings   int
+	// Sales is the payment ledger summed per tier, highest amount first;
+	// Revenue is the total charged.
+	Sales   []TierRevenue
+	Revenue int
+	// TopTrainer is the trainer with the most bookings, zero if none.
+	TopTrainer         int
+	TopTrainerBookings int
+}
+
+// computeStats counts users who joined, bookings made and subscriptions
+// bought in [from, to).
+func computeStats(s AppState, from, to time.Time) Stats {
+	st := Stats{From: from, To: to}
+	in := func(unix int64) bool {
+		return unix != 0 && unix >= from.Unix() && unix < to.Unix()
+	}
+	for _, u := range s.Users {
+		if in(u.OnboardedAt) {
+			st.NewMembers++
+		}
+	}
+	st.Sales, st.Revenue = revenueByTier(s.Payments, from, to)
+	perTrainer := map[int]int{}
+	for _, b := range s.Bookings {
+		if in(b.BookedAt) {
+			st.Bookings++
+			perTrainer[b.Trainer]++
+		}
+	}
+	for id, n := range perTrainer {
+		if n > st.TopTrainerBookings || (n == st.TopTrainerBookings && id < st.TopTrainer) {
+			st.TopTrainer, st.TopTrainerBookings = id, n
+		}
+	}
+	return st
+}
+
+// empty reports whether nothing happened in the period.
+func (st Stats) empty() bool {
+	return st.NewMembers == 0 && st.Bookings == 0 && len(st.Sales) == 0
+}
+
+func digestText(s AppState, st Stats) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Итоги недели %s — %s\n\n", st.From.In(gymLoc).Format("02.01"), st.To.In(gymLoc).AddDate(0, 0, -1).Format("02.01"))
+	fmt.Fprintf(&b, "Новых участников: %d\n", st.NewMembers)
+	fmt.Fprintf(&b, "Записей: %d\n", st.Bookings)
+	if len(st.Sales) > 0 {
+		b.WriteString("\nПродажи абонементов:\n")
+		for _, r := range st.Sales {
+			fmt.Fprintf(&b, "• %s — %d шт., %s\n", tierName(r.Tier), r.Count, formatMoney(r.Amount))
+		}
+		fmt.Fprintf(&b, "Выручка: %s\n", formatMoney(st.Revenue))
+	}
+	if st.TopTrainer != 0 {
+		name := fmt.Sprintf("#%d", st.TopTrainer)
+		for _, t := range s.Trainers {
+			if t.ID == st.TopTrainer {
+				name = t.Name
+			}
+		}
+		fmt.Fprintf(&b, "\nЛучший тренер недели: %s, записей: %d", name, st.TopTrainerBookings)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// nextDigest returns the first digestDay at digestAt after t, gym time.
+func nextDigest(t time.Time) time.Time {
+	t = t.In(gymLoc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), digestAt/60, digestAt%60, 0, 0, gymLoc)
+	next = next.AddDate(0, 0, (int(digestDay)-int(next.Weekday())+7)%7)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// runWeeklyDigest sends the past week's stats to digestChat on schedule. A
+// week without any activity is skipped.
+func runWeeklyDigest(bot Sender) {
+	if !digestEnabled || digestChat == 0 {
+		return
+	}
+	for {
+		at := nextDigest(now())
+		time.Sleep(time.Until(at))
+		s := snapshot()
+		st := computeStats(s, at.AddDate(0, 0, -7), at)
+		if st.empty() {
+			log.Printf("weekly digest: no activity, skipped")
+			continue
+		}
+		_ = notify(bot, telegram.NewMessage(digestChat, digestText(s, st)))
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDigestRevenueComesFromTheLedger(t *testing.T) {
+	setupState(t)
+	at := now()
+	s := AppState{Payments: []Payment{
+		{UserID: 1, Tier: "gold", Amount: 20000, At: at.AddDate(0, 0, -2).Unix()},
+		{UserID: 2, Tier: "gold", Amount: 25000, At: at.AddDate(0, 0, -1).Unix()},
+		{UserID: 3, Tier: "silver", Amount: 18000, At: at.AddDate(0, 0, -10).Unix()},
+	}}
+
+	st := computeStats(s, at.AddDate(0, 0, -7), at)
+	if st.Revenue != 45000 {
+		t.Errorf("revenue = %d, want 45000", st.Revenue)
+	}
+	if len(st.Sales) != 1 || st.Sales[0].Tier != "gold" || st.Sales[0].Count != 2 {
+		t.Errorf("sales = %+v, want 2 gold", st.Sales)
+	}
+	text := digestText(s, st)
+	if !strings.Contains(text, "Выручка: "+formatMoney(45000)) {
+		t.Errorf("digest does not show the charged total:\n%s", text)
+	}
+	if strings.Contains(text, "оценка") {
+		t.Errorf("digest still calls revenue an estimate:\n%s", text)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	outboxWorkers   = 4
+	outboxQueueSize = 256
+	// outboxWait is how long send blocks on a full queue before giving up.
+	outboxWait = 2 * time.Second
+
+	maxSendRetries = 3
+	retryBaseDelay = 200 * time.Millisecond
+)
+
+var (
+	errOutboxFull   = errors.New("outgoing queue is full")
+	errOutboxClosed = errors.New("outgoing queue is closed")
+)
+
+// outbox delivers outgoing messages off the update loop. It is nil until
+// startOutbox is called; until then sends go straight to Telegram.
+var outbox *dispatcher
+
+// outJob is one unit of work for a worker: a single message or a batch that
+// must go out in order. result, if set, receives the outcome of the last
+// message. paced jobs also wait out chatSendInterval for their chat.
+type outJob struct {
+	bot    Sender
+	chatID int64
+	msgs   []telegram.Chattable
+	paced  bool
+	result chan sendResult
+}
+
+type sendResult struct {
+	msg telegram.Message
+	err error
+}
+
+// dispatcher runs a fixed pool of workers, each with its own queue. Jobs are
+// assigned to a worker by chat, so messages to one chat keep their order
+// while a slow chat doesn't hold up the others.
+type dispatcher struct {
+	queues  []chan outJob
+	wg      sync.WaitGroup
+	pending atomic.Int64
+
+	// mu keeps close from closing a queue under an enqueue in progress.
+	mu     sync.RWMutex
+	closed bool
+}
+
+func startOutbox(workers, queueSize int) *dispatcher {
+	d := &dispatcher{queues: make([]chan outJob, workers)}
+	for i := range d.queues {
+		d.queues[i] = make(chan outJob, queueSize)
+		d.wg.Add(1)
+		go d.run(d.queues[i])
+	}
+	return d
+}
+
+func (d *dispatcher) run(q chan outJob) {
+	defer d.wg.Done()
+	for job := range q {
+		res := deliverBatch(job.bot, job.chatID, job.msgs, job.paced)
+		if job.result != nil {
+			job.result <- res
+		}
+		d.pending.Add(-1)
+	}
+}
+
+// enqueue hands job to the worker for its chat. If that worker's queue stays
+// full for outboxWait the job is dropped and errOutboxFull returned.
+func (d *dispatcher) enqueue(job outJob) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return errOutboxClosed
+	}
+	q := d.queues[uint64(job.chatID)%uint64(len(d.queues))]
+	d.pending.Add(1)
+	select {
+	case q <- job:
+		return nil
+	default:
+	}
+	t := time.NewTimer(outboxWait)
+	defer t.Stop()
+	select {
+	case q <- job:
+		return nil
+	case <-t.C:
+		d.pending.Add(-1)
+		metricSendErrors.Add(int64(len(job.msgs)))
+		log.Printf("outbox: chat %d: queue full, dropping %d messages", job.chatID, len(job.msgs))
+		return errOutboxFull
+	}
+}
+
+// idle reports whether every queued job has been delivered.
+func (d *dispatcher) idle() bool {
+	return d.pending.Load() == 0
+}
+
+// close stops accepting jobs and waits for the queued ones to go out.
+func (d *dispatcher) close() {
+	d.mu.Lock()
+	d.closed = true
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// dispatch runs job through the outbox, or inline when there is none. With
+// wait it blocks until the job is delivered and returns the result.
+func dispatch(job outJob, wait bool) sendResult {
+	if outbox == nil {
+		return deliverBatch(job.bot, job.chatID, job.msgs, job.paced)
+	}
+	if wait {
+		job.result = make(chan sendResult, 1)
+	}
+	if err := outbox.enqueue(job); err != nil {
+		return sendResult{err: err}
+	}
+	if !wait {
+		return sendResult{}
+	}
+	return <-job.result
+}
+
+// deliverBatch sends msgs in order, pausing batchSendInterval between them
+// (or chatSendInterval when paced), and gives up after maxBatchFailures
+// failures in a row.
+func deliverBatch(bot Sender, chatID int64, msgs []telegram.Chattable, paced bool) sendResult {
+	var res sendResult
+	failures := 0
+	for i, m := range msgs {
+		if paced {
+			waitChat(chatID)
+		} else if i > 0 {
+			time.Sleep(batchSendInterval)
+		}
+		res = deliver(bot, m)
+		if res.err == nil {
+			failures = 0
+			continue
+		}
+		failures++
+		if failures >= maxBatchFailures {
+			log.Printf("sendBatch: chat %d: stopping after %d failed sends", chatID, failures)
+			break
+		}
+	}
+	return res
+}
+
+// deliver sends one message, retrying rate limits, server errors and
+// network failures with backoff. Other API errors (bad request, blocked by
+// the user) are not retried. Every attempt counts against the bot-wide
+// send rate.
+func deliver(bot Sender, c telegram.Chattable) sendResult {
+	for attempt := 0; ; attempt++ {
+		waitGlobal()
+		var res sendResult
+		if _, ok := c.(telegram.DeleteMessageConfig); ok {
+			// deleteMessage returns true rather than a Message.
+			_, res.err = bot.Request(c)
+		} else {
+			res.msg, res.err = bot.Send(c)
+		}
+		if res.err == nil {
+			return res
+		}
+		delay, retry := retryDelay(res.err, attempt)
+		if !retry || attempt >= maxSendRetries {
+			metricSendErrors.Add(1)
+			log.Printf("send error: %v", res.err)
+			return res
+		}
+		time.Sleep(delay)
+	}
+}
+
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	backoff := retryBaseDelay << attempt
+	var tgErr *telegram.Error
+	if !errors.As(err, &tgErr) {
+		return backoff, true
+	}
+	if tgErr.RetryAfter > 0 {
+		return time.Duration(tgErr.RetryAfter) * time.Second, true
+	}
+	return backoff, tgErr.Code == 429 || tgErr.Code >= 500
+}
+
+// chatOf returns the chat a message is addressed to, for queue assignment.
+func chatOf(c telegram.Chattable) int64 {
+	switch m := c.(type) {
+	case telegram.MessageConfig:
+		return m.ChatID
+	case telegram.PhotoConfig:
+		return m.ChatID
+	case telegram.DocumentConfig:
+		return m.ChatID
+	case telegram.LocationConfig:
+		return m.ChatID
+	case telegram.EditMessageTextConfig:
+		return m.ChatID
+	case telegram.EditMessageReplyMarkupConfig:
+		return m.ChatID
+	case telegram.DeleteMessageConfig:
+		return m.ChatID
+	}
+	return 0
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const dryRunUserID int64 = 1000
+
+// dryRunClient stands in for the Telegram HTTP API. Outgoing calls are
+// printed to stdout and getUpdates is served from a script, one update per
+// line:
+//
+//	/start              a message (commands are detected automatically)
+//	Тренеры             plain text, as if a reply keyboard button was pressed
+//	cb trainer_1        a callback query with the given data, attached to the
+//	                    last message with an inline keyboard sent to the chat
+//	@42 /start          any of the above sent by user 42 instead of the default
+//	{"update_id": ...}  a raw Update in JSON
+//
+// Empty lines and lines starting with # are skipped.
+type dryRunClient struct {
+	mu      sync.Mutex
+	nextMsg int
+	// inline is the last message per chat that carried an inline keyboard.
+	inline map[int64]int
+
+	// scriptMu is separate from mu so that sends aren't blocked while
+	// getUpdates waits for the next script line.
+	scriptMu sync.Mutex
+	script   *bufio.Scanner
+	nextID   int
+	onEOF    func()
+	finished bool
+}
+
+func newDryRunClient(r io.Reader) *dryRunClient {
+	return &dryRunClient{script: bufio.NewScanner(r), nextID: 1, nextMsg: 1, inline: map[int64]int{}}
+}
+
+func (c *dryRunClient) messageID() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextMsg
+	c.nextMsg++
+	return id
+}
+
+func (c *dryRunClient) setInline(chatID int64, msgID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inline[chatID] = msgID
+}
+
+// callbackMessageID returns the message a scripted button tap belongs to.
+func (c *dryRunClient) callbackMessageID(chatID int64) int {
+	c.mu.Lock()
+	id, ok := c.inline[chatID]
+	c.mu.Unlock()
+	if ok {
+		return id
+	}
+	return c.messageID()
+}
+
+func (c *dryRunClient) Do(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+	params := readDryRunParams(req)
+
+	var result interface{} = true
+	switch method {
+	case "getMe":
+		result = telegram.User{ID: 1, IsBot: true, FirstName: "Dry Run", UserName: "dry_run_bot"}
+	case "getUpdates":
+		result = c.readUpdates()
+	case "answerCallbackQuery":
+		if params.Get("text") != "" {
+			kind := method
+			if params.Get("show_alert") == "true" {
+				kind += " (alert)"
+			}
+			fmt.Printf("<- %s: %s\n", kind, params.Get("text"))
+		}
+	default:
+		fmt.Printf("<- %s chat=%s\n", method, params.Get("chat_id"))
+		for _, k := range []string{"text", "caption", "reply_markup"} {
+			if v := params.Get(k); v != "" {
+				fmt.Printf("   %s: %s\n", k, v)
+			}
+		}
+		chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+		sent := telegram.Message{MessageID: c.messageID(), Chat: &telegram.Chat{ID: chatID, Type: "private"}}
+		if strings.HasPrefix(method, "send") && strings.Contains(params.Get("reply_markup"), "inline_keyboard") {
+			c.setInline(chatID, sent.MessageID)
+		}
+		if method == "sendPhoto" {
+			sent.Photo = []telegram.PhotoSize{{FileID: fmt.Sprintf("dry-run-photo-%d", sent.MessageID)}}
+		}
+		result = sent
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(telegram.APIResponse{Ok: true, Result: raw})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func readDryRunParams(req *http.Request) url.Values {
+	if req.Body == nil {
+		return url.Values{}
+	}
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return url.Values{}
+	}
+	mediaType, mparams, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		r := multipart.NewReader(bytes.NewReader(b), mparams["boundary"])
+		form, err := r.ReadForm(1 << 20)
+		if err != nil {
+			return url.Values{}
+		}
+		return url.Values(form.Value)
+	}
+	v, _ := url.ParseQuery(string(b))
+	return v
+}
+
+func (c *dryRunClient) readUpdates() []telegram.Update {
+	c.scriptMu.Lock()
+	defer c.scriptMu.Unlock()
+	// Hand out one line at a time, after the previous update was handled, so
+	// a scripted button tap sees the messages sent in reply to the line
+	// before it.
+	for !alreadyHandled(c.nextID-1) || (outbox != nil && !outbox.idle()) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	for c.script.Scan() {
+		line := strings.TrimSpace(c.script.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := c.parseLine(line)
+		if err != nil {
+			log.Printf("dry-run: skip line %q: %v", line, err)
+			continue
+		}
+		fmt.Printf("-> %s\n", line)
+		return []telegram.Update{u}
+	}
+	if !c.finished {
+		c.finished = true
+		log.Printf("dry-run: script finished")
+		if c.onEOF != nil {
+			c.onEOF()
+		}
+	}
+	return []telegram.Update{}
+}
+
+func (c *dryRunClient) parseLine(line string) (telegram.Update, error) {
+	id := c.nextID
+	c.nextID++
+
+	if strings.HasPrefix(line, "{") {
+		var u telegram.Update
+		if err := json.Unmarshal([]byte(line), &u); err != nil {
+			return u, err
+		}
+		u.UpdateID = id
+		return u, nil
+	}
+
+	userID := dryRunUserID
+	if strings.HasPrefix(line, "@") {
+		head, rest, _ := strings.Cut(line[1:], " ")
+		v, err := strconv.ParseInt(head, 10, 64)
+		if err != nil {
+			return telegram.Update{}, fmt.Errorf("bad user id %q", head)
+		}
+		userID, line = v, strings.TrimSpace(rest)
+	}
+	from := &telegram.User{ID: userID, FirstName: "Dry", LastName: "Run", LanguageCode: "ru"}
+	chat := &telegram.Chat{ID: userID, Type: "private"}
+
+	if data, ok := strings.CutPrefix(line, "cb "); ok {
+		return telegram.Update{
+			UpdateID: id,
+			CallbackQuery: &telegram.CallbackQuery{
+				ID:      strconv.Itoa(id),
+				From:    from,
+				Message: &telegram.Message{MessageID: c.callbackMessageID(chat.ID), Chat: chat},
+				Data:    strings.TrimSpace(data),
+			},
+		}, nil
+	}
+
+	msg := &telegram.Message{MessageID: c.messageID(), From: from, Chat: chat, Text: line}
+	if strings.HasPrefix(line, "/") {
+		cmd, _, _ := strings.Cut(line, " ")
+		msg.Entities = []telegram.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(cmd)}}
+	}
+	return telegram.Update{UpdateID: id, Message: msg}, nil
+}
+
+// newDryRunBot builds a BotAPI backed by dryRunClient. scriptPath "" or "-"
+// reads the script from stdin. The updates channel is closed once the script
+// is exhausted, which ends the main loop.
+func newDryRunBot(scriptPath string) (*telegram.BotAPI, error) {
+	var r io.Reader = os.Stdin
+	if scriptPath != "" && scriptPath != "-" {
+		f, err := os.Open(scriptPath)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	client := newDryRunClient(r)
+	// Continue numbering after the last update the state has seen, otherwise
+	// a second run would be skipped as already handled.
+	stateMu.Lock()
+	client.nextID = state.LastUpdateID + 1
+	stateMu.Unlock()
+	bot, err := telegram.NewBotAPIWithClient("dry-run", telegram.APIEndpoint, client)
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	client.onEOF = func() { once.Do(bot.StopReceivingUpdates) }
+	return bot, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestFormatMoney(t *testing.T) {
+	defer func(c string) { currency = c }(currency)
+	for _, c := range []struct {
+		currency string
+		n        int
+		want     string
+	}{
+		{"₸", 0, "0 ₸"},
+		{"₸", 999, "999 ₸"},
+		{"₸", 1000, "1 000 ₸"},
+		{"₸", 25000, "25 000 ₸"},
+		{"₸", 1234567, "1 234 567 ₸"},
+		{"₸", -18000, "-18 000 ₸"},
+		{"USD", 100000, "100 000 USD"},
+		{"₽", 12000, "12 000 ₽"},
+	} {
+		currency = c.currency
+		if got := formatMoney(c.n); got != c.want {
+			t.Errorf("formatMoney(%d) in %s = %q, want %q", c.n, c.currency, got, c.want)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	setupState(t)
+	thisYear := time.Date(2026, time.October, 15, 12, 0, 0, 0, time.UTC)
+	nextYear := time.Date(2027, time.January, 2, 12, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		at   time.Time
+		lang string
+		want string
+	}{
+		{thisYear, "ru", "15 октября"},
+		{thisYear, "kk", "15 қазан"},
+		{thisYear, "en", "October 15"},
+		{nextYear, "ru", "2 января 2027"},
+		{nextYear, "kk", "2 қаңтар 2027"},
+		{nextYear, "en", "January 2, 2027"},
+		{thisYear, "de", "2026-10-15"},
+	} {
+		if got := formatDate(c.at, c.lang); got != c.want {
+			t.Errorf("formatDate(%s, %s) = %q, want %q", c.at.Format(dateLayout), c.lang, got, c.want)
+		}
+	}
+}
+
+func TestFormatSessionForUsesUserLanguage(t *testing.T) {
+	setupState(t)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Lang: "en"}
+	state.Users[8] = &User{ID: 8, Lang: "kk"}
+	stateMu.Unlock()
+	for id, want := range map[int64]string{
+		7:  "March 10 10:00–11:00 (UTC+00:00)",
+		8:  "10 наурыз 10:00–11:00 (UTC+00:00)",
+		99: "10 марта 10:00–11:00 (UTC+00:00)",
+	} {
+		if got := formatSessionFor(id, 1, today(), "10:00"); got != want {
+			t.Errorf("user %d: got %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	line := strings.Repeat("ж", 99)
+	var lines []string
+	for range 90 {
+		lines = append(lines, line)
+	}
+	text := strings.Join(lines, "\n") // 90*100-1 = 8999 characters
+
+	chunks := splitMessage(text, maxMessageLen)
+	if len(chunks) != 3 {
+		t.Fatalf("got %d chunks, want 3", len(chunks))
+	}
+	for i, c := range chunks {
+		if n := utf8.RuneCountInString(c); n > maxMessageLen {
+			t.Errorf("chunk %d has %d characters", i, n)
+		}
+		if strings.HasPrefix(c, "\n") || strings.HasSuffix(c, "\n") {
+			t.Errorf("chunk %d starts or ends with a line break", i)
+		}
+	}
+	if got := strings.Join(chunks, "\n"); got != text {
+		t.Error("chunks do not join back into the text")
+	}
+
+	long := strings.Repeat("ы", 9000)
+	chunks = splitMessage(long, maxMessageLen)
+	if len(chunks) != 3 || utf8.RuneCountInString(chunks[2]) != 9000-2*maxMessageLen {
+		t.Errorf("a 9000-character line split into %d chunks", len(chunks))
+	}
+	if strings.Join(chunks, "") != long {
+		t.Error("cutting a long line lost characters")
+	}
+
+	if got := splitMessage("short", maxMessageLen); len(got) != 1 || got[0] != "short" {
+		t.Errorf("short text split into %q", got)
+	}
+}
+
+func TestSendLongSendsPartsInOrder(t *testing.T) {
+	setupState(t)
+	text := strings.Repeat("1", 4000) + "\n" + strings.Repeat("2", 4000) + "\n" + strings.Repeat("3", 1000)
+	bot := &fakeSender{}
+	if err := sendLong(bot, 7, text); err != nil {
+		t.Fatal(err)
+	}
+	got := bot.texts()
+	if len(got) != 3 || got[0][0] != '1' || got[1][0] != '2' || got[2][0] != '3' {
+		t.Errorf("sent %d parts, want 3 in order", len(got))
+	}
+}
+
+func TestEscapeHTML(t *testing.T) {
+	for in, want := range map[string]string{
+		"Айдос":             "Айдос",
+		"<b>Evil</b> & _x_": "&lt;b&gt;Evil&lt;/b&gt; &amp; _x_",
+		`"quoted" 'single'`: "&#34;quoted&#34; &#39;single&#39;",
+		"a < b > c":         "a &lt; b &gt; c",
+		"&amp; already":     "&amp;amp; already",
+	} {
+		if got := escapeHTML(in); got != want {
+			t.Errorf("escapeHTML(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestStaffNotificationEscapesUserText(t *testing.T) {
+	setupState(t)
+	defer func(id int64) { staffChat = id }(staffChat)
+	staffChat = -100
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Name: "<b>Evil</b> & _x_", Phone: "<+7>"}
+	stateMu.Unlock()
+
+	bot := &fakeSender{}
+	notifyStaffBooking(bot, Booking{ID: 1, UserID: 7, Trainer: 1, TimeSlot: "10:00", Date: today(), Code: "ABC", Note: "</i>"}, "Новая <запись>")
+	if len(bot.sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(bot.sent))
+	}
+	m := bot.sent[0].(telegram.MessageConfig)
+	if m.ParseMode != telegram.ModeHTML {
+		t.Errorf("parse mode = %q, want HTML", m.ParseMode)
+	}
+	for _, want := range []string{
+		"<b>Новая &lt;запись&gt;</b>",
+		"&lt;b&gt;Evil&lt;/b&gt; &amp; _x_",
+		"тел. &lt;+7&gt;",
+		"Комментарий: &lt;/i&gt;",
+	} {
+		if !strings.Contains(m.Text, want) {
+			t.Errorf("message does not contain %q:\n%s", want, m.Text)
+		}
+	}
+}
+
+func TestSendLongIsCapped(t *testing.T) {
+	setupState(t)
+	text := strings.Repeat(strings.Repeat("а", 99)+"\n", 1000) // about 25 parts
+	bot := &fakeSender{}
+	if err := sendLong(bot, 7, text); err != nil {
+		t.Fatal(err)
+	}
+	got := bot.texts()
+	if len(got) != maxBatchMessages {
+		t.Fatalf("sent %d messages, want %d", len(got), maxBatchMessages)
+	}
+	if last := got[len(got)-1]; !strings.HasPrefix(last, "… Вывод обрезан") {
+		t.Errorf("last message = %q, want the truncation note", last)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// holdTTL is how long a slot stays reserved for a user who picked it but
+// hasn't confirmed yet.
+var holdTTL = 5 * time.Minute
+
+// Hold is a soft reservation: the slot is taken off sale while the user
+// confirms. A user has at most one hold at a time.
+type Hold struct {
+	UserID   int64  `json:"user_id"`
+	Trainer  int    `json:"trainer"`
+	TimeSlot string `json:"time_slot"`
+	Date     string `json:"date"`
+	At       int64  `json:"at"`
+
+	// ChatID and MessageID locate the confirmation prompt, which is edited
+	// to say the time ran out when the hold expires.
+	ChatID    int64 `json:"chat_id,omitempty"`
+	MessageID int   `json:"message_id,omitempty"`
+
+	// Expires is set when the hold is a freed slot offered to a subscriber
+	// (see offers.go). Such a hold runs out at Expires rather than after
+	// holdTTL and then passes to the next user in Queue.
+	Expires int64   `json:"expires,omitempty"`
+	Queue   []int64 `json:"queue,omitempty"`
+}
+
+func confirmHoldKeyboard(trainerID int, slot string) telegram.InlineKeyboardMarkup {
+	return telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+		telegram.NewInlineKeyboardButtonData("✅ Подтвердить", fmt.Sprintf("confirm_%d_%s", trainerID, slot)),
+		telegram.NewInlineKeyboardButtonData("✖️ Отмена", fmt.Sprintf("unhold_%d", trainerID)),
+	))
+}
+
+// holdSlot takes slot off sale for the user, replacing any hold they had.
+func holdSlot(userID int64, trainerID int, slot string) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	ensureTodayLocked()
+
+	if err := checkUserLimits(userID, trainerID); err != nil {
+		return err
+	}
+	releaseHoldLocked(userID)
+
+	idx := -1
+	for i := range state.Trainers {
+		if state.Trainers[i].ID == trainerID {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return fmt.Errorf("тренер не найден")
+	}
+	date := today()
+	if err := checkSlotRulesLocked(userID, state.Trainers[idx], date, slot, 0); err != nil {
+		return err
+	}
+	pos := -1
+	for i, s := range state.Trainers[idx].Slots {
+		if s == slot {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return errSlotTaken
+	}
+	slots := state.Trainers[idx].Slots
+	state.Trainers[idx].Slots = append(slots[:pos], slots[pos+1:]...)
+
+	state.Holds = append(state.Holds, Hold{
+		UserID:   userID,
+		Trainer:  trainerID,
+		TimeSlot: slot,
+		Date:     date,
+		At:       now().Unix(),
+	})
+	return nil
+}
+
+// confirmHold turns the user's hold into a booking. The hold is checked and
+// consumed under the same lock the sweeper uses, so it can't expire halfway.
+func confirmHold(userID int64, trainerID int, slot string) (Booking, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	pos := -1
+	for i, h := range state.Holds {
+		if !h.isOffer() && h.UserID == userID && h.Trainer == trainerID && h.TimeSlot == slot && h.Date == today() {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return Booking{}, fmt.Errorf("время на подтверждение истекло, выберите слот заново.")
+	}
+	releaseHoldAt(pos)
+	return bookSlotLocked(userID, trainerID, slot)
+}
+
+func releaseHold(userID int64) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	releaseHoldLocked(userID)
+}
+
+// releaseHoldLocked drops the user's hold, if any. Offers made to the user
+// are left alone. Callers must hold stateMu.
+func releaseHoldLocked(userID int64) {
+	for i, h := range state.Holds {
+		if h.UserID == userID && !h.isOffer() {
+			releaseHoldAt(i)
+			return
+		}
+	}
+}
+
+// releaseHoldAt removes hold i and puts its slot back on sale if the hold is
+// for today's schedule. Callers must hold stateMu.
+func releaseHoldAt(i int) {
+	h := state.Holds[i]
+	state.Holds = append(state.Holds[:i], state.Holds[i+1:]...)
+	if h.Date != today() {
+		return
+	}
+	for j := range state.Trainers {
+		if state.Trainers[j].ID == h.Trainer {
+			state.Trainers[j].Slots = insertSlot(state.Trainers[j].Slots, h.TimeSlot)
+			return
+		}
+	}
+}
+
+// holdTTLText is holdTTL for the confirmation prompt, e.g. "5 мин.".
+func holdTTLText() string {
+	return durationText(holdTTL)
+}
+
+// durationText is d in whole minutes, or seconds when shorter than a minute.
+func durationText(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%d сек.", int(d/time.Second))
+	}
+	return fmt.Sprintf("%d мин.", int(d/time.Minute))
+}
+
+// setHoldPrompt records where the user's confirmation prompt was sent.
+func setHoldPrompt(userID int64, chatID int64, messageID int) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for i := range state.Holds {
+		if state.Holds[i].UserID == userID && !state.Holds[i].isOffer() {
+			state.Holds[i].ChatID, state.Holds[i].MessageID = chatID, messageID
+			return
+		}
+	}
+}
+
+// reapExpiredHolds releases holds older than ttl and returns them. Offers
+// are reaped by reapExpiredOffers.
+func reapExpiredHolds(ttl time.Duration) []Hold {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	cutoff := now().Add(-ttl).Unix()
+	var expired []Hold
+	for i := 0; i < len(state.Holds); {
+		if !state.Holds[i].isOffer() && state.Holds[i].At <= cutoff {
+			expired = append(expired, state.Holds[i])
+			releaseHoldAt(i)
+			continue
+		}
+		i++
+	}
+	return expired
+}
+
+// expiredHoldMessage turns h's confirmation prompt into a notice that the
+// time ran out, with a way back to the schedule.
+func expiredHoldMessage(h Hold) telegram.EditMessageTextConfig {
+	kb := telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+		telegram.NewInlineKeyboardButtonData("🗓 Выбрать время заново", fmt.Sprintf("book_%d", h.Trainer)),
+	))
+	return telegram.NewEditMessageTextAndMarkup(h.ChatID, h.MessageID,
+		fmt.Sprintf("⌛ Время на подтверждение вышло, %s снова свободно.", h.TimeSlot), kb)
+}
+
+func sweepHolds(bot Sender, interval time.Duration) {
+	for range time.Tick(interval) {
+		expireHolds(bot)
+	}
+}
+
+// expireHolds releases the holds and offers whose time ran out, tells their
+// users, and offers the freed slots to whoever is next in line.
+func expireHolds(bot Sender) {
+	expired := reapExpiredHolds(holdTTL)
+	lapsed, next := reapExpiredOffers()
+	if len(expired) == 0 && len(lapsed) == 0 {
+		return
+	}
+	log.Printf("released %d expired slot holds and %d offers", len(expired), len(lapsed))
+	if err := saveState(); err != nil {
+		log.Printf("save state: %v", err)
+	}
+	for _, h := range expired {
+		if h.MessageID != 0 {
+			_ = notify(bot, expiredHoldMessage(h))
+		}
+	}
+	for _, h := range lapsed {
+		if h.MessageID != 0 {
+			_ = notify(bot, expiredOfferMessage(h))
+		}
+	}
+	for _, h := range next {
+		sendOffer(bot, h)
+	}
+}
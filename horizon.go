@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// maxBookingHorizonDays caps BOOKING_HORIZON_DAYS.
+const maxBookingHorizonDays = 90
+
+// bookingHorizonDays is how many days ahead booking is open: a session can
+// be booked for today through today plus this many days.
+var bookingHorizonDays = 7
+
+var errBeyondHorizon = errors.New("запись на эту дату ещё не открыта")
+
+func loadHorizonConfig() {
+	v := os.Getenv("BOOKING_HORIZON_DAYS")
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > maxBookingHorizonDays {
+		configProblem("BOOKING_HORIZON_DAYS: expected 0..%d, got %q", maxBookingHorizonDays, v)
+		return
+	}
+	bookingHorizonDays = n
+}
+
+// lastBookableDate is the furthest date booking is open for.
+func lastBookableDate() string {
+	return now().In(gymLoc).AddDate(0, 0, bookingHorizonDays).Format(dateLayout)
+}
+
+// daySlotsLocked returns t's free slots on date. Only today's list is
+// stored (Trainer.Slots); any other day's is generated from the schedule
+// minus the bookings and holds already made for it, so every day up to the
+// horizon has its slots without a list per day in the state file. Callers
+// must hold stateMu.
+func daySlotsLocked(t Trainer, date string) []string {
+	if date == today() {
+		return slices.Clone(t.Slots)
+	}
+	taken := map[string]bool{}
+	for _, b := range state.Bookings {
+		if b.Trainer == t.ID && b.Date == date {
+			taken[b.TimeSlot] = true
+		}
+	}
+	for _, h := range state.Holds {
+		if h.Trainer == t.ID && h.Date == date {
+			taken[h.TimeSlot] = true
+		}
+	}
+	var free []string
+	for _, s := range buildSlots(t.Schedule) {
+		if !taken[s] {
+			free = append(free, s)
+		}
+	}
+	return free
+}
+
+// checkBookingHorizon rejects dates past the booking horizon. Dates are
+// compared as YYYY-MM-DD strings.
+func checkBookingHorizon(date string) error {
+	if _, err := time.ParseInLocation(dateLayout, date, gymLoc); err != nil {
+		return err
+	}
+	if date > lastBookableDate() {
+		return errBeyondHorizon
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestBookingHorizonBoundary(t *testing.T) {
+	setupState(t)
+	defer func(d int) { bookingHorizonDays = d }(bookingHorizonDays)
+	for _, days := range []int{0, 7, 30} {
+		bookingHorizonDays = days
+		last := now().AddDate(0, 0, days).Format(dateLayout)
+		next := now().AddDate(0, 0, days+1).Format(dateLayout)
+		if err := checkBookingHorizon(last); err != nil {
+			t.Errorf("horizon %d: day %s rejected: %v", days, last, err)
+		}
+		err := checkBookingHorizon(next)
+		if !errors.Is(err, errBeyondHorizon) {
+			t.Errorf("horizon %d: day %s: got %v, want errBeyondHorizon", days, next, err)
+		} else if err.Error() != "запись на эту дату ещё не открыта" {
+			t.Errorf("error text = %q", err.Error())
+		}
+	}
+}
+
+func TestDaySlotsLeaveOutBookedTimes(t *testing.T) {
+	setupState(t)
+	date := now().AddDate(0, 0, 3).Format(dateLayout)
+	stateMu.Lock()
+	state.Bookings = append(state.Bookings, Booking{ID: 1, UserID: 5, Trainer: 1, TimeSlot: "10:00", Date: date})
+	tr := state.Trainers[0]
+	got := daySlotsLocked(tr, date)
+	stateMu.Unlock()
+
+	want := slices.DeleteFunc(buildSlots(tr.Schedule), func(s string) bool { return s == "10:00" })
+	if !slices.Equal(got, want) {
+		t.Errorf("slots on %s = %v, want %v", date, got, want)
+	}
+}
+
+func TestWeeklySeriesStopsAtTheHorizon(t *testing.T) {
+	setupState(t)
+	defer func(d int) { bookingHorizonDays = d }(bookingHorizonDays)
+	bookingHorizonDays = 14
+	stateMu.Lock()
+	state.Users[5] = &User{ID: 5, HasPaid: true}
+	stateMu.Unlock()
+	b, err := bookSlot(5, 1, "10:00")
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+	limit := 10
+	stateMu.Lock()
+	state.Users[5].BookingLimitOverride = &limit
+	stateMu.Unlock()
+
+	bot := &fakeSender{}
+	handleUpdate(bot, callbackUpdate(5, fmt.Sprintf("repeat_%d", b.ID)))
+
+	var dates []string
+	for _, ub := range upcomingUserBookings(5) {
+		if ub.Date > lastBookableDate() {
+			t.Errorf("booked %s past the horizon %s", ub.Date, lastBookableDate())
+		}
+		dates = append(dates, ub.Date)
+	}
+	want := []string{today(), now().AddDate(0, 0, 7).Format(dateLayout), now().AddDate(0, 0, 14).Format(dateLayout)}
+	if !slices.Equal(dates, want) {
+		t.Errorf("series booked %v, want %v", dates, want)
+	}
+	if !bot.sentContaining(errBeyondHorizon.Error()) {
+		t.Errorf("reply %q does not say why the later weeks were skipped", bot.texts())
+	}
+}
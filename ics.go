@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// icsReminder is how long before the session the calendar alarm fires.
+const icsReminder = time.Hour
+
+// bookingStart returns the session start of b in the gym's time zone.
+func bookingStart(b Booking) (time.Time, error) {
+	return time.ParseInLocation(dateLayout+" 15:04", b.Date+" "+b.TimeSlot, gymLoc)
+}
+
+// icsEscape escapes a TEXT value as required by RFC 5545.
+func icsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// icsFold splits a content line into 75-octet pieces as RFC 5545 requires,
+// never cutting a UTF-8 sequence in half.
+func icsFold(line string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range line {
+		size := utf8.RuneLen(r)
+		if n+size > 75 {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
+
+// buildICS renders b as a single-event iCalendar file with a reminder alarm.
+func buildICS(b Booking, t Trainer) ([]byte, error) {
+	start, err := bookingStart(b)
+	if err != nil {
+		return nil, err
+	}
+	const stamp = "20060102T150405Z"
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//" + icsEscape(gymName) + "//fitness-bot//RU",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"BEGIN:VEVENT",
+		fmt.Sprintf("UID:booking-%d-%d@fitness-bot", b.ID, b.UserID),
+		"DTSTAMP:" + now().UTC().Format(stamp),
+		"DTSTART:" + start.UTC().Format(stamp),
+		"DTEND:" + start.Add(t.sessionLength()).UTC().Format(stamp),
+		"SUMMARY:" + icsEscape("Тренировка с "+t.Name),
+		"LOCATION:" + icsEscape(gymName),
+	}
+	if b.Note != "" {
+		lines = append(lines, "DESCRIPTION:"+icsEscape(b.Note))
+	}
+	lines = append(lines,
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"DESCRIPTION:"+icsEscape("Тренировка с "+t.Name),
+		fmt.Sprintf("TRIGGER:-PT%dM", int(icsReminder/time.Minute)),
+		"END:VALARM",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	)
+	for i := range lines {
+		lines[i] = icsFold(lines[i])
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n"), nil
+}
+
+// icsDocument wraps the calendar file for b as a Telegram document. It
+// reports false if the trainer or the booking time can't be resolved.
+func icsDocument(chatID int64, b Booking) (telegram.DocumentConfig, bool) {
+	tr, _ := getTrainerByID(b.Trainer)
+	if tr == nil {
+		return telegram.DocumentConfig{}, false
+	}
+	data, err := buildICS(b, *tr)
+	if err != nil {
+		return telegram.DocumentConfig{}, false
+	}
+	doc := telegram.NewDocument(chatID, telegram.FileBytes{Name: fmt.Sprintf("booking-%d.ics", b.ID), Bytes: data})
+	doc.Caption = "Добавьте тренировку в календарь"
+	return doc, true
+}
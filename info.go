@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// gymInfo is the static contact information shown by /info. Every field is
+// optional; empty ones are left out of the reply.
+var gymInfo struct {
+	Address string
+	Phone   string
+	Hours   string
+
+	HasLocation bool
+	Lat, Lon    float64
+}
+
+func loadGymInfo() {
+	if v := strings.TrimSpace(os.Getenv("GYM_NAME")); v != "" {
+		gymName = v
+	}
+	gymInfo.Address = strings.TrimSpace(os.Getenv("GYM_ADDRESS"))
+	gymInfo.Phone = strings.TrimSpace(os.Getenv("GYM_PHONE"))
+	gymInfo.Hours = strings.TrimSpace(os.Getenv("GYM_HOURS"))
+
+	lat, lon := os.Getenv("GYM_LAT"), os.Getenv("GYM_LON")
+	if lat == "" && lon == "" {
+		return
+	}
+	la, err1 := strconv.ParseFloat(lat, 64)
+	lo, err2 := strconv.ParseFloat(lon, 64)
+	if err1 != nil || err2 != nil || la < -90 || la > 90 || lo < -180 || lo > 180 {
+		configProblem("GYM_LAT/GYM_LON: invalid coordinates %q, %q", lat, lon)
+		return
+	}
+	gymInfo.HasLocation, gymInfo.Lat, gymInfo.Lon = true, la, lo
+}
+
+func gymInfoText() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Фитнес зал %s", gymName))
+	if gymInfo.Address != "" {
+		sb.WriteString("\n\n📍 Адрес: " + gymInfo.Address)
+	}
+	if gymInfo.Phone != "" {
+		sb.WriteString("\n📞 Телефон: " + gymInfo.Phone)
+	}
+	if gymInfo.Hours != "" {
+		sb.WriteString("\n🕒 Часы работы: " + gymInfo.Hours)
+	}
+	return sb.String()
+}
+
+func handleInfo(bot Sender, msg *telegram.Message) {
+	m := telegram.NewMessage(msg.Chat.ID, gymInfoText())
+	m.ReplyMarkup = mainMenuKeyboard(userLang(msg.From.ID))
+	if !gymInfo.HasLocation {
+		_ = send(bot, m)
+		return
+	}
+	_ = sendBatch(bot, msg.Chat.ID, m, telegram.NewLocation(msg.Chat.ID, gymInfo.Lat, gymInfo.Lon))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// maxInlineResults is Telegram's limit per inline query answer.
+	maxInlineResults = 50
+	// inlineCacheSeconds lets Telegram reuse an answer for the same query.
+	inlineCacheSeconds = 300
+	maxInlineDescLen   = 100
+)
+
+// inlineMode enables "@bot <query>" trainer search from any chat. Set
+// INLINE_MODE=1 and turn on inline mode for the bot in @BotFather.
+var inlineMode = false
+
+func loadInlineConfig() {
+	switch v := os.Getenv("INLINE_MODE"); v {
+	case "", "0":
+		inlineMode = false
+	case "1":
+		inlineMode = true
+	default:
+		configProblem("INLINE_MODE: expected 0 or 1, got %q", v)
+	}
+}
+
+// inlineTrainerResults lists the trainers matching query; an empty query
+// lists everyone.
+func inlineTrainerResults(query string) []interface{} {
+	trainers := searchTrainers(query)
+	if len(trainers) > maxInlineResults {
+		trainers = trainers[:maxInlineResults]
+	}
+	results := make([]interface{}, 0, len(trainers))
+	for _, t := range trainers {
+		desc := t.Bio
+		if r := []rune(desc); len(r) > maxInlineDescLen {
+			desc = string(r[:maxInlineDescLen-1]) + "…"
+		}
+		a := telegram.NewInlineQueryResultArticle(strconv.Itoa(t.ID), t.Name,
+			fmt.Sprintf("🏋️ %s — тренер фитнес зала %s\n\n%s", t.Name, gymName, t.Bio))
+		a.Description = desc
+		if botUsername != "" {
+			kb := telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+				telegram.NewInlineKeyboardButtonURL("Открыть в боте", fmt.Sprintf("https://t.me/%s?start=trainer_%d", botUsername, t.ID)),
+			))
+			a.ReplyMarkup = &kb
+		}
+		results = append(results, a)
+	}
+	return results
+}
+
+func handleInlineQuery(bot Sender, q *telegram.InlineQuery) {
+	if !inlineMode {
+		return
+	}
+	_, _ = bot.Request(telegram.InlineConfig{
+		InlineQueryID: q.ID,
+		Results:       inlineTrainerResults(q.Query),
+		CacheTime:     inlineCacheSeconds,
+	})
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"html"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Messages go out as plain text unless they need formatting, in which case
+// they use HTML. Every user- or admin-supplied value (names, bios, notes,
+// phone numbers) in an HTML message must pass through escapeHTML, or a name
+// like "<b>" breaks the message or injects markup.
+
+// escapeHTML escapes s for a message sent with ParseMode HTML.
+func escapeHTML(s string) string {
+	return html.EscapeString(s)
+}
+
+// htmlMessage is telegram.NewMessage for text already escaped as HTML.
+func htmlMessage(chatID int64, text string) telegram.MessageConfig {
+	m := telegram.NewMessage(chatID, text)
+	m.ParseMode = telegram.ModeHTML
+	return m
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// menuMsgIDs remembers the one navigation message per chat that inline menus
+// are drawn into, so tapping around doesn't leave a trail of old menus.
+var (
+	menuMsgIDs = map[int64]int{}
+	menuMsgMu  sync.Mutex
+)
+
+func activeMenu(chatID int64) int {
+	menuMsgMu.Lock()
+	defer menuMsgMu.Unlock()
+	return menuMsgIDs[chatID]
+}
+
+func setActiveMenu(chatID int64, msgID int) {
+	menuMsgMu.Lock()
+	defer menuMsgMu.Unlock()
+	menuMsgIDs[chatID] = msgID
+}
+
+// showMenu makes m the chat's active menu. If the tap came from the active
+// menu (fromID), that message is edited in place. Otherwise m is sent as a
+// new message and the previous menu is deleted. m must carry an inline
+// keyboard.
+func showMenu(bot Sender, m telegram.MessageConfig, fromID int) error {
+	kb, _ := m.ReplyMarkup.(telegram.InlineKeyboardMarkup)
+	prev := activeMenu(m.ChatID)
+	if fromID != 0 && fromID == prev {
+		_, err := sendSync(bot, telegram.NewEditMessageTextAndMarkup(m.ChatID, prev, m.Text, kb))
+		if err == nil || strings.Contains(err.Error(), "message is not modified") {
+			return nil
+		}
+		// Too old to edit or already gone: fall through and send a new one.
+	}
+	sent, err := sendSync(bot, m)
+	if err != nil {
+		return err
+	}
+	setActiveMenu(m.ChatID, sent.MessageID)
+	if prev != 0 && prev != sent.MessageID {
+		_ = send(bot, telegram.NewDeleteMessage(m.ChatID, prev))
+	}
+	return nil
+}
+
+// showPhotoMenu is showMenu for a photo. A text message can't be edited into
+// a photo, so p is always sent anew and the previous menu deleted.
+func showPhotoMenu(bot Sender, p telegram.PhotoConfig) (telegram.Message, error) {
+	prev := activeMenu(p.ChatID)
+	sent, err := sendSync(bot, p)
+	if err != nil {
+		return sent, err
+	}
+	setActiveMenu(p.ChatID, sent.MessageID)
+	if prev != 0 && prev != sent.MessageID {
+		_ = send(bot, telegram.NewDeleteMessage(p.ChatID, prev))
+	}
+	return sent, nil
+}
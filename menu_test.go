@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMainMenuKeyboardMatchesBuild(t *testing.T) {
+	for _, lang := range append([]string{"xx"}, supportedLangs...) {
+		if got, want := mainMenuKeyboard(lang), buildMainMenu(lang); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: cached menu differs from a fresh build", lang)
+		}
+	}
+}
+
+func TestMainMenuFollowsLabelChanges(t *testing.T) {
+	old := menuLabels["en"][actionPay]
+	t.Cleanup(func() {
+		menuLabels["en"][actionPay] = old
+		invalidateMainMenus()
+	})
+	if !reflect.DeepEqual(mainMenuKeyboard("en"), buildMainMenu("en")) {
+		t.Fatal("cached menu differs from a fresh build")
+	}
+
+	menuLabels["en"][actionPay] = "💳 Top up"
+	invalidateMainMenus()
+	kb := mainMenuKeyboard("en")
+	if !reflect.DeepEqual(kb, buildMainMenu("en")) {
+		t.Errorf("menu after invalidation = %v, want the new labels", kb.Keyboard)
+	}
+	if ru := mainMenuKeyboard("ru"); reflect.DeepEqual(ru, kb) {
+		t.Error("languages share one cached menu")
+	}
+}
+
+func BenchmarkMainMenu(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		mainMenuKeyboard(supportedLangs[i%len(supportedLangs)])
+	}
+}
+
+func TestMenuActionFor(t *testing.T) {
+	for _, c := range []struct {
+		text string
+		want menuAction
+	}{
+		{"Тренеры", actionTrainers},
+		{"  тренеры!! ", actionTrainers},
+		{"ТРЕНЕР", actionTrainers},
+		{"❌ Отменить запись", actionCancel},
+		{"отменить   запись", actionCancel},
+		{"⚡ Ближайшее свободное", actionEarliest},
+		{"Мои записи.", actionMyBookings},
+		{"Prices", actionPrices},
+		{"my bookings", actionMyBookings},
+		{"💳 Төлеу", actionPay},
+		{"Өткізіп жіберу", actionSkipPhone},
+	} {
+		got, ok := menuActionFor(c.text)
+		if !ok || got != c.want {
+			t.Errorf("menuActionFor(%q) = %q, %v; want %q", c.text, got, ok, c.want)
+		}
+	}
+	for _, text := range []string{"", "привет", "тренеры завтра", "😀"} {
+		if a, ok := menuActionFor(text); ok {
+			t.Errorf("menuActionFor(%q) = %q, want no match", text, a)
+		}
+	}
+}
+
+func TestMenuSynonymsAreNormalized(t *testing.T) {
+	for k := range menuSynonyms {
+		if normalizeText(k) != k {
+			t.Errorf("synonym %q is not in normalized form", k)
+		}
+	}
+	for lang, labels := range menuLabels {
+		for a := range menuLabels["ru"] {
+			if _, ok := labels[a]; !ok {
+				t.Errorf("%s: no label for %q", lang, a)
+			}
+		}
+	}
+}
+
+func TestLangFromCode(t *testing.T) {
+	for code, want := range map[string]string{
+		"ru":      "ru",
+		"kk":      "kk",
+		"en":      "en",
+		"en-US":   "en",
+		"EN-gb":   "en",
+		" kk ":    "kk",
+		"de":      "ru",
+		"":        "ru",
+		"english": "ru",
+	} {
+		if got := langFromCode(code); got != want {
+			t.Errorf("langFromCode(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestNewUserGetsClientLanguage(t *testing.T) {
+	setupState(t)
+	u := getOrCreateUser(7, "Test", "kk", nil)
+	if u.Lang != "kk" {
+		t.Errorf("new user language = %q, want kk", u.Lang)
+	}
+	if u := getOrCreateUser(7, "Test", "en-US", nil); u.Lang != "kk" {
+		t.Errorf("a later update changed the language to %q", u.Lang)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+)
+
+// Counters exported on /metrics in the Prometheus text format.
+var (
+	metricUpdates    atomic.Int64
+	metricBookings   atomic.Int64
+	metricPayments   atomic.Int64
+	metricSendErrors atomic.Int64
+)
+
+func activeUserCount() int {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return activeUserCountLocked()
+}
+
+// activeUserCountLocked counts users with an active subscription. Callers
+// must hold stateMu.
+func activeUserCountLocked() int {
+	n := 0
+	for _, u := range state.Users {
+		if u.IsActive() {
+			n++
+		}
+	}
+	return n
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	counters := []struct {
+		name, help string
+		value      int64
+	}{
+		{"fitnessbot_updates_handled_total", "Telegram updates handled.", metricUpdates.Load()},
+		{"fitnessbot_bookings_created_total", "Bookings created.", metricBookings.Load()},
+		{"fitnessbot_payments_total", "Subscriptions paid.", metricPayments.Load()},
+		{"fitnessbot_send_errors_total", "Failed Telegram sends.", metricSendErrors.Load()},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value)
+	}
+	fmt.Fprintf(w, "# HELP fitnessbot_active_users Users with an active subscription.\n# TYPE fitnessbot_active_users gauge\nfitnessbot_active_users %d\n", activeUserCount())
+}
+
+// serveMetrics exposes /metrics on addr. It is started only when METRICS_ADDR
+// is set.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metricsHandler)
+	log.Printf("metrics listening on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("metrics server: %v", err)
+	}
+}
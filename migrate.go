@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// migrations upgrade a decoded state one schema version at a time:
+// migrations[i] turns version i into version i+1. Append new steps at the
+// end; never edit or reorder released ones.
+var migrations = []func(AppState) AppState{
+	migrateBookingIDs,
+	migrateBookingCodes,
+	migrateOnboarded,
+	migratePaymentLedger,
+}
+
+// currentSchemaVersion is the version written by this build.
+var currentSchemaVersion = len(migrations)
+
+// migrateState runs every migration newer than s.SchemaVersion in order.
+func migrateState(s AppState) (AppState, error) {
+	if s.SchemaVersion > currentSchemaVersion {
+		return s, fmt.Errorf("state schema version %d is newer than supported %d", s.SchemaVersion, currentSchemaVersion)
+	}
+	for v := s.SchemaVersion; v < currentSchemaVersion; v++ {
+		s = migrations[v](s)
+		s.SchemaVersion = v + 1
+	}
+	return s, nil
+}
+
+// migrateBookingIDs (v0→v1) numbers bookings saved before they had IDs.
+func migrateBookingIDs(s AppState) AppState {
+	for i := range s.Bookings {
+		if s.Bookings[i].ID == 0 {
+			s.NextBookingID++
+			s.Bookings[i].ID = s.NextBookingID
+		}
+	}
+	return s
+}
+
+// migrateBookingCodes (v1→v2) gives check-in codes to bookings made before
+// codes existed.
+func migrateBookingCodes(s AppState) AppState {
+	for i := range s.Bookings {
+		if s.Bookings[i].Code == "" {
+			s.Bookings[i].Code = newBookingCode(s.Bookings)
+		}
+	}
+	return s
+}
+
+// onboardedBeforeTracking is the OnboardedAt given to users who joined
+// before onboarding existed. Their real join time is unknown; one second
+// after the epoch counts as onboarded without ever falling inside a
+// digest's week, so they aren't reported as new members.
+const onboardedBeforeTracking int64 = 1
+
+// migrateOnboarded (v2→v3) marks users who existed before onboarding as
+// already onboarded, so only new users see it.
+func migrateOnboarded(s AppState) AppState {
+	for _, u := range s.Users {
+		if u.OnboardedAt == 0 {
+			u.OnboardedAt = onboardedBeforeTracking
+		}
+	}
+	return s
+}
+
+// migratePaymentLedger (v3→v4) seeds the payment ledger from each user's
+// last purchase, the only payment record kept before the ledger existed, and
+// gives charge IDs to entries logged without one. Admin grants looked the
+// same as purchases back then, so they are included as the old weekly
+// digest counted them.
+func migratePaymentLedger(s AppState) AppState {
+	var seeded []Payment
+	for _, u := range s.Users {
+		if u.PaidAt == 0 || hasPaymentAt(s.Payments, u.ID, u.PaidAt) {
+			continue
+		}
+		amount := u.PaidPrice
+		if amount == 0 {
+			if t, ok := findTier(u.Tier); ok {
+				amount = t.Price
+			}
+		}
+		seeded = append(seeded, Payment{UserID: u.ID, Tier: u.Tier, Amount: amount, At: u.PaidAt, ChargeID: fmt.Sprintf("legacy-%d", u.ID)})
+	}
+	sort.Slice(seeded, func(i, j int) bool { return seeded[i].At < seeded[j].At })
+	s.Payments = append(seeded, s.Payments...)
+	for i := range s.Payments {
+		if s.Payments[i].ChargeID == "" {
+			s.NextPaymentID++
+			s.Payments[i].ChargeID = fmt.Sprintf("sim-%d", s.NextPaymentID)
+		}
+	}
+	return s
+}
+
+// hasPaymentAt reports whether the ledger already has the user's payment
+// made at (within a few seconds of) unix time at.
+func hasPaymentAt(payments []Payment, userID int64, at int64) bool {
+	for _, p := range payments {
+		if p.UserID == userID && p.At >= at-5 && p.At <= at+5 {
+			return true
+		}
+	}
+	return false
+}
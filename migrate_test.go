@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+func TestMigratedUsersAreNotNewMembers(t *testing.T) {
+	setupState(t)
+	at := now()
+	s := AppState{SchemaVersion: 2, Users: map[int64]*User{
+		1: {ID: 1},
+		2: {ID: 2, OnboardedAt: at.AddDate(0, 0, -1).Unix()},
+	}}
+	s = migrateOnboarded(s)
+	if s.Users[1].OnboardedAt == 0 {
+		t.Fatal("existing user was not marked as onboarded")
+	}
+	st := computeStats(s, at.AddDate(0, 0, -7), at)
+	if st.NewMembers != 1 {
+		t.Errorf("new members = %d, want 1 (only the user onboarded this week)", st.NewMembers)
+	}
+}
+
+func TestMigrateFromVersionZero(t *testing.T) {
+	setupState(t)
+	paidAt := now().AddDate(0, 0, -3).Unix()
+	s := AppState{
+		Users: map[int64]*User{
+			7: {ID: 7, HasPaid: true, Tier: "silver", PaidAt: paidAt},
+		},
+		Bookings: []Booking{
+			{UserID: 7, Trainer: 1, TimeSlot: "09:00", Date: today()},
+			{ID: 5, UserID: 8, Trainer: 2, TimeSlot: "10:00", Date: today()},
+			{UserID: 9, Trainer: 3, TimeSlot: "11:30", Date: today()},
+		},
+	}
+
+	s, err := migrateState(s)
+	if err != nil {
+		t.Fatalf("migrateState: %v", err)
+	}
+	if s.SchemaVersion != currentSchemaVersion {
+		t.Errorf("schema version = %d, want %d", s.SchemaVersion, currentSchemaVersion)
+	}
+	ids := map[int]bool{}
+	codes := map[string]bool{}
+	for _, b := range s.Bookings {
+		if b.ID == 0 || ids[b.ID] {
+			t.Errorf("booking %+v has a missing or duplicate ID", b)
+		}
+		if b.Code == "" || codes[b.Code] {
+			t.Errorf("booking %+v has a missing or duplicate code", b)
+		}
+		ids[b.ID], codes[b.Code] = true, true
+	}
+	if s.Bookings[1].ID != 5 {
+		t.Errorf("existing booking ID changed to %d", s.Bookings[1].ID)
+	}
+	if s.Users[7].OnboardedAt != onboardedBeforeTracking {
+		t.Errorf("OnboardedAt = %d, want %d", s.Users[7].OnboardedAt, onboardedBeforeTracking)
+	}
+	if len(s.Payments) != 1 || s.Payments[0].Amount != 18000 || s.Payments[0].At != paidAt {
+		t.Errorf("payments = %+v, want the silver purchase seeded from the user", s.Payments)
+	}
+
+	again, err := migrateState(s)
+	if err != nil || len(again.Payments) != 1 || again.SchemaVersion != currentSchemaVersion {
+		t.Errorf("migrating a current state changed it: %+v, %v", again.Payments, err)
+	}
+}
+
+func TestMigrateRejectsNewerSchema(t *testing.T) {
+	if _, err := migrateState(AppState{SchemaVersion: currentSchemaVersion + 1}); err == nil {
+		t.Error("a state from a newer build was accepted")
+	}
+}
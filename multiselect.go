@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// multiSelectKeyboard is the schedule in multi-select mode: tapping a slot
+// toggles it and the book button takes every selected slot at once.
+func multiSelectKeyboard(trainerID int, selected []string) telegram.InlineKeyboardMarkup {
+	kb := slotsKeyboard(trainerID, func(s string) string {
+		return fmt.Sprintf("msel_%d_%s", trainerID, s)
+	}, fmt.Sprintf("book_%d", trainerID))
+	for _, row := range kb.InlineKeyboard {
+		for i := range row {
+			slot := strings.TrimPrefix(*row[i].CallbackData, fmt.Sprintf("msel_%d_", trainerID))
+			if slices.Contains(selected, slot) {
+				row[i].Text = "✅ " + row[i].Text
+			}
+		}
+	}
+	if len(selected) > 0 {
+		book := telegram.NewInlineKeyboardRow(telegram.NewInlineKeyboardButtonData(
+			fmt.Sprintf("Записать выбранные (%d)", len(selected)), fmt.Sprintf("mbook_%d", trainerID)))
+		last := len(kb.InlineKeyboard) - 1
+		kb.InlineKeyboard = append(kb.InlineKeyboard[:last], book, kb.InlineKeyboard[last])
+	}
+	return kb
+}
+
+// toggleSelection adds slot to the user's multi-select state for trainerID,
+// or removes it if it is already selected. Selecting for another trainer
+// starts a new selection.
+func toggleSelection(userID int64, trainerID int, slot string) []string {
+	st, ok := getConversation(userID)
+	if !ok || st.Step != stepMultiSelect || st.TrainerID != trainerID {
+		st = ConversationState{Step: stepMultiSelect, TrainerID: trainerID}
+	}
+	if i := slices.Index(st.Selected, slot); i >= 0 {
+		st.Selected = slices.Delete(st.Selected, i, i+1)
+	} else {
+		st.Selected = append(st.Selected, slot)
+		slices.Sort(st.Selected)
+	}
+	setConversation(userID, st)
+	return st.Selected
+}
+
+// bookSlots books every slot with the trainer or none of them: if one
+// booking fails, the ones already made in this call are rolled back.
+func bookSlots(userID int64, trainerID int, slots []string) ([]Booking, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	nextID := state.NextBookingID
+	var made []Booking
+	for _, s := range slots {
+		b, err := bookSlotLocked(userID, trainerID, s)
+		if err != nil {
+			rollbackBookingsLocked(made)
+			state.NextBookingID = nextID
+			return nil, fmt.Errorf("%s: %w", s, err)
+		}
+		made = append(made, b)
+	}
+	return made, nil
+}
+
+// rollbackBookingsLocked removes bookings and returns their slots to the
+// trainers. Callers must hold stateMu.
+func rollbackBookingsLocked(bookings []Booking) {
+	for _, b := range bookings {
+		state.Bookings = slices.DeleteFunc(state.Bookings, func(x Booking) bool { return x.ID == b.ID })
+		for i := range state.Trainers {
+			if state.Trainers[i].ID == b.Trainer {
+				state.Trainers[i].Slots = insertSlot(state.Trainers[i].Slots, b.TimeSlot)
+			}
+		}
+	}
+}
+
+func bookedSlotsText(bookings []Booking) string {
+	times := make([]string, len(bookings))
+	for i, b := range bookings {
+		times[i] = formatSessionFor(b.UserID, b.Trainer, b.Date, b.TimeSlot)
+	}
+	return strings.Join(times, ", ")
+}
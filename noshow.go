@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	// noShowTracking turns on no-show marking, NOSHOW_TRACKING=1. Sessions
+	// that end without a /checkin count against the user, and noShowLimit
+	// no-shows within noShowWindowDays suspend booking until the oldest of
+	// them leaves the window.
+	noShowTracking   = false
+	noShowLimit      = 3
+	noShowWindowDays = 30
+)
+
+func loadNoShowConfig() {
+	noShowTracking = os.Getenv("NOSHOW_TRACKING") == "1"
+	if v := os.Getenv("NOSHOW_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			noShowLimit = n
+		} else {
+			configProblem("NOSHOW_LIMIT: expected a positive number, got %q", v)
+		}
+	}
+	if v := os.Getenv("NOSHOW_WINDOW_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			noShowWindowDays = n
+		} else {
+			configProblem("NOSHOW_WINDOW_DAYS: expected a positive number of days, got %q", v)
+		}
+	}
+}
+
+// recentNoShows returns how many of the user's no-shows fall within the
+// window ending at at, and when the oldest of those leaves it.
+func recentNoShows(u User, at time.Time) (n int, until time.Time) {
+	from := at.AddDate(0, 0, -noShowWindowDays).Unix()
+	for _, ts := range u.NoShowAt {
+		if ts > from {
+			n++
+			if t := time.Unix(ts, 0).AddDate(0, 0, noShowWindowDays); until.IsZero() || t.Before(until) {
+				until = t
+			}
+		}
+	}
+	return n, until
+}
+
+// checkNoShowSuspension rejects bookings from users with too many recent
+// no-shows. Callers must hold stateMu.
+func checkNoShowSuspension(userID int64) error {
+	if !noShowTracking {
+		return nil
+	}
+	u, ok := state.Users[userID]
+	if !ok {
+		return nil
+	}
+	if n, until := recentNoShows(*u, now()); n >= noShowLimit {
+		return fmt.Errorf("запись приостановлена до %s: пропущено тренировок без отметки — %d.", until.In(gymLoc).Format("02.01.2006"), n)
+	}
+	return nil
+}
+
+// noShowLookback limits marking to sessions that ended recently, so turning
+// tracking on doesn't punish users for months of unrecorded history.
+const noShowLookback = 24 * time.Hour
+
+// bookingEndLocked is when b's session ends. Callers must hold stateMu.
+func bookingEndLocked(b Booking) (time.Time, error) {
+	start, err := bookingStart(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	length := time.Duration(defaultSessionMinutes) * time.Minute
+	for _, t := range state.Trainers {
+		if t.ID == b.Trainer {
+			length = t.sessionLength()
+		}
+	}
+	return start.Add(length), nil
+}
+
+// markNoShows flags bookings whose session ended before at without a
+// check-in and records them on their users. It returns how many were marked.
+func markNoShows(at time.Time) int {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	marked := 0
+	for i := range state.Bookings {
+		b := &state.Bookings[i]
+		if b.CheckedIn || b.NoShow {
+			continue
+		}
+		end, err := bookingEndLocked(*b)
+		if err != nil || end.After(at) || at.Sub(end) > noShowLookback {
+			continue
+		}
+		b.NoShow = true
+		if u, ok := state.Users[b.UserID]; ok {
+			u.NoShowCount++
+			u.NoShowAt = append(u.NoShowAt, end.Unix())
+		}
+		marked++
+	}
+	return marked
+}
+
+func sweepNoShows(interval time.Duration) {
+	if !noShowTracking {
+		return
+	}
+	for range time.Tick(interval) {
+		n := markNoShows(now())
+		if n == 0 {
+			continue
+		}
+		log.Printf("marked %d bookings as no-shows", n)
+		if err := saveState(); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+}
+
+// checkIn confirms attendance for the booking with the given code. A
+// booking already marked as a no-show is cleared, so late check-ins still
+// count.
+func checkIn(code string) (Booking, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !strings.HasPrefix(code, "AF-") {
+		code = "AF-" + code
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	for i := range state.Bookings {
+		b := &state.Bookings[i]
+		if b.Code != code {
+			continue
+		}
+		if b.CheckedIn {
+			return *b, fmt.Errorf("посещение уже отмечено")
+		}
+		if b.NoShow {
+			b.NoShow = false
+			if u, ok := state.Users[b.UserID]; ok && u.NoShowCount > 0 {
+				u.NoShowCount--
+				if end, err := bookingEndLocked(*b); err == nil {
+					if j := slices.Index(u.NoShowAt, end.Unix()); j != -1 {
+						u.NoShowAt = slices.Delete(u.NoShowAt, j, j+1)
+					}
+				}
+			}
+		}
+		b.CheckedIn = true
+		return *b, nil
+	}
+	return Booking{}, fmt.Errorf("запись не найдена")
+}
+
+// handleCheckIn is /checkin <code>, for the front desk.
+func handleCheckIn(bot Sender, msg *telegram.Message) {
+	if !requireStaff(bot, msg) {
+		return
+	}
+	code := strings.TrimSpace(msg.CommandArguments())
+	if code == "" {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /checkin <код записи>")
+		return
+	}
+	b, err := checkIn(code)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось отметить: "+err.Error())
+		return
+	}
+	_ = saveState()
+	_ = send(bot, htmlMessage(msg.Chat.ID, "<b>✅ Посещение отмечено.</b>\n\n"+staffBookingText(b)))
+}
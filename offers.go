@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// offerTTL is how long a freed slot is kept for the subscriber it was
+// offered to before it passes to the next one in line.
+var offerTTL = 10 * time.Minute
+
+// A freed slot is offered to the trainer's subscribers one at a time, in the
+// order they subscribed. The offer is a Hold with Expires set: the slot stays
+// off sale until the subscriber books it, declines, or the time runs out, and
+// then passes to the next user in Queue. After the last one it goes back on
+// sale.
+
+var errOfferExpired = errors.New("предложение уже истекло.")
+
+// isOffer reports whether h is a slot offer rather than the user's own hold.
+func (h Hold) isOffer() bool {
+	return h.Expires != 0
+}
+
+// takeSlotLocked takes slot off sale, reporting whether it was free.
+// Callers must hold stateMu.
+func takeSlotLocked(trainerID int, slot string) bool {
+	for i := range state.Trainers {
+		if state.Trainers[i].ID != trainerID {
+			continue
+		}
+		pos := slices.Index(state.Trainers[i].Slots, slot)
+		if pos == -1 {
+			return false
+		}
+		slots := state.Trainers[i].Slots
+		state.Trainers[i].Slots = append(slots[:pos], slots[pos+1:]...)
+		return true
+	}
+	return false
+}
+
+// offerLocked takes today's slot off sale and offers it to the first of
+// queue for offerTTL. It reports false when queue is empty or the slot is no
+// longer free. Callers must hold stateMu.
+func offerLocked(queue []int64, trainerID int, slot string) (Hold, bool) {
+	if len(queue) == 0 || !takeSlotLocked(trainerID, slot) {
+		return Hold{}, false
+	}
+	h := Hold{
+		UserID:   queue[0],
+		Trainer:  trainerID,
+		TimeSlot: slot,
+		Date:     today(),
+		At:       now().Unix(),
+		Expires:  now().Add(offerTTL).Unix(),
+		Queue:    slices.Clone(queue[1:]),
+	}
+	state.Holds = append(state.Holds, h)
+	return h, true
+}
+
+// passOfferLocked ends offer i and offers its slot to the next user in the
+// queue, if any; otherwise the slot goes back on sale. Callers must hold
+// stateMu.
+func passOfferLocked(i int) (Hold, bool) {
+	h := state.Holds[i]
+	releaseHoldAt(i)
+	if h.Date != today() {
+		return Hold{}, false
+	}
+	return offerLocked(h.Queue, h.Trainer, h.TimeSlot)
+}
+
+// findOfferLocked returns the index of today's offer of slot with trainerID
+// to userID, or -1. Callers must hold stateMu.
+func findOfferLocked(userID int64, trainerID int, slot string) int {
+	date := today()
+	for i, h := range state.Holds {
+		if h.isOffer() && h.UserID == userID && h.Trainer == trainerID && h.TimeSlot == slot && h.Date == date {
+			return i
+		}
+	}
+	return -1
+}
+
+// startOffer offers a slot that was just put back on sale to the first of
+// the subscribers in ids.
+func startOffer(ids []int64, trainerID int, slot string) (Hold, bool) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return offerLocked(ids, trainerID, slot)
+}
+
+// acceptOffer books the slot offered to the user. If the booking is refused,
+// the offer stays theirs until it runs out or they decline it.
+func acceptOffer(userID int64, trainerID int, slot string) (Booking, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	i := findOfferLocked(userID, trainerID, slot)
+	if i == -1 {
+		return Booking{}, errOfferExpired
+	}
+	h := state.Holds[i]
+	releaseHoldAt(i)
+	b, err := bookSlotLocked(userID, trainerID, slot)
+	if err != nil && takeSlotLocked(trainerID, slot) {
+		state.Holds = append(state.Holds, h)
+	}
+	return b, err
+}
+
+// declineOffer passes the slot offered to the user on. It returns the offer
+// made to the next subscriber, if there is one.
+func declineOffer(userID int64, trainerID int, slot string) (Hold, bool, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	i := findOfferLocked(userID, trainerID, slot)
+	if i == -1 {
+		return Hold{}, false, errOfferExpired
+	}
+	next, ok := passOfferLocked(i)
+	return next, ok, nil
+}
+
+// reapExpiredOffers ends the offers whose time ran out and passes each slot
+// on. It returns the expired offers and the offers that replaced them.
+func reapExpiredOffers() (expired, next []Hold) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	t := now().Unix()
+	for i := 0; i < len(state.Holds); {
+		h := state.Holds[i]
+		if !h.isOffer() || h.Expires > t {
+			i++
+			continue
+		}
+		expired = append(expired, h)
+		if n, ok := passOfferLocked(i); ok {
+			next = append(next, n)
+		}
+	}
+	return expired, next
+}
+
+// setOfferPrompt records where the message announcing offer h was sent.
+func setOfferPrompt(h Hold, chatID int64, messageID int) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if i := findOfferLocked(h.UserID, h.Trainer, h.TimeSlot); i != -1 {
+		state.Holds[i].ChatID, state.Holds[i].MessageID = chatID, messageID
+	}
+}
+
+func offerKeyboard(trainerID int, slot string) telegram.InlineKeyboardMarkup {
+	return telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+		telegram.NewInlineKeyboardButtonData("✅ Записаться", fmt.Sprintf("offer_%d_%s", trainerID, slot)),
+		telegram.NewInlineKeyboardButtonData("✖️ Отказаться", fmt.Sprintf("offerno_%d_%s", trainerID, slot)),
+	))
+}
+
+// sendOffer tells the subscriber about the slot offered to them.
+func sendOffer(bot Sender, h Hold) {
+	tr, _ := getTrainerByID(h.Trainer)
+	if tr == nil {
+		return
+	}
+	chatID := chatIDFor(h.UserID)
+	m := telegram.NewMessage(chatID, fmt.Sprintf("🔔 У тренера %s освободилось время: %s.\n⏳ Оно закреплено за вами на %s, потом перейдёт следующему в очереди.",
+		tr.Name, formatSessionFor(h.UserID, tr.ID, h.Date, h.TimeSlot), durationText(offerTTL)))
+	m.ReplyMarkup = offerKeyboard(tr.ID, h.TimeSlot)
+	sent, err := notifySync(bot, m)
+	if err != nil {
+		log.Printf("offer %s to user %d: %v", h.TimeSlot, h.UserID, err)
+		return
+	}
+	setOfferPrompt(h, chatID, sent.MessageID)
+}
+
+// expiredOfferMessage turns the offer's message into a notice that it ran
+// out, with a way to the trainer's schedule.
+func expiredOfferMessage(h Hold) telegram.EditMessageTextConfig {
+	kb := telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+		telegram.NewInlineKeyboardButtonData("🗓 Расписание тренера", fmt.Sprintf("book_%d", h.Trainer)),
+	))
+	return telegram.NewEditMessageTextAndMarkup(h.ChatID, h.MessageID,
+		fmt.Sprintf("⌛ Предложение истекло: время %s передано дальше.", h.TimeSlot), kb)
+}
+
+// handleOfferCallback handles the buttons of a slot offer: offer_ books the
+// slot, offerno_ passes it to the next subscriber.
+func handleOfferCallback(bot Sender, cq *telegram.CallbackQuery, user User) {
+	chatID := cq.Message.Chat.ID
+	data, decline := strings.CutPrefix(cq.Data, "offerno_")
+	parts := strings.SplitN(strings.TrimPrefix(data, "offer_"), "_", 2)
+	if len(parts) != 2 {
+		return
+	}
+	var trainerID int
+	fmt.Sscanf(parts[0], "%d", &trainerID)
+	slot := parts[1]
+
+	if decline {
+		next, ok, err := declineOffer(cq.From.ID, trainerID, slot)
+		if err != nil {
+			_ = replyError(bot, chatID, "Не удалось отказаться: "+err.Error())
+			return
+		}
+		_ = saveState()
+		refreshKeyboard(bot, cq.Message, telegram.NewInlineKeyboardMarkup())
+		_ = send(bot, telegram.NewMessage(chatID, "Хорошо, время передано следующему в очереди."))
+		if ok {
+			sendOffer(bot, next)
+		}
+		return
+	}
+
+	if !user.IsActive() {
+		m := telegram.NewMessage(chatID, "Срок действия абонемента истёк. Продлите его, чтобы записаться:")
+		m.ReplyMarkup = pricingKeyboard()
+		_ = send(bot, m)
+		return
+	}
+	b, err := acceptOffer(cq.From.ID, trainerID, slot)
+	if err != nil {
+		_ = replyError(bot, chatID, "Не удалось записаться: "+err.Error())
+		return
+	}
+	metricBookings.Add(1)
+	_ = saveState()
+	notifyStaffBooking(bot, b, "🆕 Новая запись")
+	refreshKeyboard(bot, cq.Message, telegram.NewInlineKeyboardMarkup())
+
+	m := telegram.NewMessage(chatID, fmt.Sprintf("Запись подтверждена! Время %s.\nКод записи: %s — назовите его на ресепшене.", formatSessionFor(b.UserID, b.Trainer, b.Date, b.TimeSlot), b.Code))
+	m.ReplyMarkup = mainMenuKeyboard(userLang(cq.From.ID))
+	msgs := []telegram.Chattable{m}
+	if doc, ok := icsDocument(chatID, b); ok {
+		msgs = append(msgs, doc)
+	}
+	_ = sendBatch(bot, chatID, msgs...)
+}
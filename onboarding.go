@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// onboardingSteps are shown one at a time to a user's first /start.
+var onboardingSteps = []string{
+	"Добро пожаловать в %s! У нас тренажёрный зал, персональные тренировки и тренеры с разной специализацией — от силовых до восстановительных программ.",
+	"Как записаться:\n1. Оплатите абонемент в разделе \"Прайс абонементов\".\n2. Откройте \"Тренеры\" и выберите тренера.\n3. Нажмите \"Запись\", выберите время и подтвердите.\nВсе ваши записи — в разделе \"Мои записи\", там же их можно отменить или перенести.",
+	"Начните с выбора абонемента — после оплаты запись к тренерам откроется сразу.",
+}
+
+// startCooldown is how long a repeated plain /start is ignored, so tapping it
+// several times doesn't stack up welcome messages.
+const startCooldown = 5 * time.Second
+
+var (
+	lastStartAt   = map[int64]time.Time{}
+	lastStartAtMu sync.Mutex
+)
+
+// startAllowed records a /start from the user and reports whether it should
+// be answered: false if the previous one was less than startCooldown ago.
+func startAllowed(userID int64) bool {
+	lastStartAtMu.Lock()
+	defer lastStartAtMu.Unlock()
+	t := now()
+	if last, ok := lastStartAt[userID]; ok && t.Sub(last) < startCooldown {
+		return false
+	}
+	lastStartAt[userID] = t
+	return true
+}
+
+// markOnboarded records that the user has seen onboarding. It reports true
+// only the first time, when onboarding should be shown.
+func markOnboarded(userID int64) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok || u.OnboardedAt != 0 {
+		return false
+	}
+	u.OnboardedAt = now().Unix()
+	return true
+}
+
+// onboardingMessage renders step i. Every step can be skipped; the last one
+// leads to the price list.
+func onboardingMessage(chatID int64, i int) telegram.MessageConfig {
+	text := onboardingSteps[i]
+	if i == 0 {
+		text = fmt.Sprintf(text, gymName)
+	}
+	m := telegram.NewMessage(chatID, text)
+	if i < len(onboardingSteps)-1 {
+		m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData("Далее ▶️", fmt.Sprintf("onboard_%d", i+1)),
+			telegram.NewInlineKeyboardButtonData("Пропустить", "onboard_done"),
+		))
+	} else {
+		m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+			telegram.NewInlineKeyboardButtonData("💳 Посмотреть абонементы", "onboard_prices"),
+			telegram.NewInlineKeyboardButtonData("В меню", "onboard_done"),
+		))
+	}
+	return m
+}
+
+// sendMainWelcome greets the user with the main menu and, once, asks for a
+// phone number.
+func sendMainWelcome(bot Sender, chatID int64, userID int64) {
+	msg := telegram.NewMessage(chatID, fmt.Sprintf("Вас приветствует фитнес зал %s!\nВыберите раздел ниже.", gymName))
+	msg.ReplyMarkup = mainMenuKeyboard(userLang(userID))
+	if !markPhoneAsked(userID) {
+		_ = send(bot, msg)
+		return
+	}
+	_ = saveState()
+	ask := telegram.NewMessage(chatID, "Оставьте, пожалуйста, номер телефона, чтобы администратор мог с вами связаться. Это необязательно.")
+	ask.ReplyMarkup = phoneRequestKeyboard(userLang(userID))
+	_ = sendBatch(bot, chatID, msg, ask)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxPayments bounds the payment ledger kept in the state file; the oldest
+// entries are dropped first.
+const maxPayments = 10000
+
+// Payment is one completed subscription purchase. The ledger in
+// AppState.Payments is append-only: entries are never edited, and a refund
+// would be recorded as a new entry rather than by removing the original.
+type Payment struct {
+	UserID int64  `json:"user_id"`
+	Tier   string `json:"tier"`
+	Amount int    `json:"amount"`
+	At     int64  `json:"at"`
+	// ChargeID identifies the charge with the payment provider. Simulated
+	// payments get "sim-<n>".
+	ChargeID string `json:"charge_id"`
+}
+
+// recordPayment appends a payment to the ledger. An empty chargeID marks a
+// simulated payment and gets a generated one. The stored entry is returned.
+func recordPayment(userID int64, tier string, amount int, chargeID string) Payment {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if chargeID == "" {
+		state.NextPaymentID++
+		chargeID = fmt.Sprintf("sim-%d", state.NextPaymentID)
+	}
+	p := Payment{UserID: userID, Tier: tier, Amount: amount, At: now().Unix(), ChargeID: chargeID}
+	state.Payments = append(state.Payments, p)
+	if n := len(state.Payments) - maxPayments; n > 0 {
+		state.Payments = append([]Payment(nil), state.Payments[n:]...)
+	}
+	return p
+}
+
+// TierRevenue is what one tier brought in over a period.
+type TierRevenue struct {
+	Tier   string
+	Count  int
+	Amount int
+}
+
+// revenueByTier sums payments made in [from, to) per tier, highest amount
+// first, and returns the grand total alongside.
+func revenueByTier(payments []Payment, from, to time.Time) ([]TierRevenue, int) {
+	byTier := map[string]*TierRevenue{}
+	total := 0
+	for _, p := range payments {
+		if p.At < from.Unix() || p.At >= to.Unix() {
+			continue
+		}
+		r, ok := byTier[p.Tier]
+		if !ok {
+			r = &TierRevenue{Tier: p.Tier}
+			byTier[p.Tier] = r
+		}
+		r.Count++
+		r.Amount += p.Amount
+		total += p.Amount
+	}
+	res := make([]TierRevenue, 0, len(byTier))
+	for _, r := range byTier {
+		res = append(res, *r)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Amount != res[j].Amount {
+			return res[i].Amount > res[j].Amount
+		}
+		return res[i].Tier < res[j].Tier
+	})
+	return res, total
+}
+
+// revenuePeriod turns a /revenue argument into a time range ending at now:
+// "day", "week" (the default), "month", "year", "all" or a number of days.
+// The label names the period in the reply.
+func revenuePeriod(arg string, now time.Time) (from time.Time, label string, err error) {
+	switch arg {
+	case "day":
+		return now.AddDate(0, 0, -1), "за сутки", nil
+	case "", "week":
+		return now.AddDate(0, 0, -7), "за неделю", nil
+	case "month":
+		return now.AddDate(0, -1, 0), "за месяц", nil
+	case "year":
+		return now.AddDate(-1, 0, 0), "за год", nil
+	case "all":
+		return time.Unix(0, 0), "за всё время", nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n <= 0 {
+		return time.Time{}, "", fmt.Errorf("неизвестный период %q", arg)
+	}
+	return now.AddDate(0, 0, -n), "за " + daysText(n), nil
+}
+
+// tierName is the display name of a tier code; codes of tiers since removed
+// are shown as is.
+func tierName(code string) string {
+	if t, ok := findTier(code); ok {
+		return t.Name
+	}
+	return code
+}
+
+func revenueText(rows []TierRevenue, total int, label string) string {
+	if len(rows) == 0 {
+		return fmt.Sprintf("Оплат %s не было.", label)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "💰 Выручка %s:\n\n", label)
+	for _, r := range rows {
+		fmt.Fprintf(&b, "• %s — %s (%d)\n", tierName(r.Tier), formatMoney(r.Amount), r.Count)
+	}
+	fmt.Fprintf(&b, "\nИтого: %s", formatMoney(total))
+	return b.String()
+}
+
+func handleRevenue(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	at := now()
+	from, label, err := revenuePeriod(strings.ToLower(strings.TrimSpace(msg.CommandArguments())), at)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось посчитать выручку: "+err.Error()+".\nИспользование: /revenue [day|week|month|year|all|<дней>]")
+		return
+	}
+	// The range is half-open; include a payment made this very second.
+	rows, total := revenueByTier(snapshot().Payments, from, at.Add(time.Second))
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, revenueText(rows, total, label)))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTierDurations(t *testing.T) {
+	setupState(t)
+	defer func(ts []Tier) { tiers = ts }(tiers)
+	tiers = []Tier{
+		{Code: "month", Name: "Месяц", Price: 25000, DurationDays: 30},
+		{Code: "quarter", Name: "Квартал", Price: 65000, DurationDays: 90},
+		{Code: "trial", Name: "Пробный", Price: 3000, DurationDays: 1},
+	}
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7}
+	state.Users[8] = &User{ID: 8, BonusDays: 14}
+	stateMu.Unlock()
+
+	for _, c := range []struct {
+		user int64
+		tier string
+		days int
+	}{
+		{7, "quarter", 90},
+		{7, "trial", 1},
+		{8, "month", 30 + 14},
+	} {
+		u, err := setUserPaid(c.user, c.tier)
+		if err != nil {
+			t.Fatalf("setUserPaid(%d, %s): %v", c.user, c.tier, err)
+		}
+		if want := now().AddDate(0, 0, c.days).Unix(); u.PaidUntil != want {
+			t.Errorf("user %d %s: paid until %d, want %d days ahead", c.user, c.tier, u.PaidUntil, c.days)
+		}
+	}
+	if u := snapshot().Users[8]; u.BonusDays != 0 {
+		t.Errorf("bonus days not used up: %d left", u.BonusDays)
+	}
+
+	text := priceText()
+	for _, want := range []string{"Квартал — 65 000 ₸ / 90 дней", "Пробный — 3 000 ₸ / 1 день", "Месяц — 25 000 ₸ / 30 дней"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("price list does not contain %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestRecordPaymentAppendsAndRotates(t *testing.T) {
+	setupState(t)
+	p1 := recordPayment(7, "gold", 25000, "")
+	p2 := recordPayment(8, "silver", 18000, "ch_123")
+	if p1.ChargeID != "sim-1" || p2.ChargeID != "ch_123" {
+		t.Errorf("charge IDs = %q, %q; want sim-1 and the provider's", p1.ChargeID, p2.ChargeID)
+	}
+	if p1.At != now().Unix() {
+		t.Errorf("payment time = %d, want now", p1.At)
+	}
+	s := snapshot()
+	if len(s.Payments) != 2 || s.Payments[0] != p1 || s.Payments[1] != p2 {
+		t.Fatalf("ledger = %+v, want both payments in order", s.Payments)
+	}
+
+	stateMu.Lock()
+	state.Payments = make([]Payment, maxPayments)
+	for i := range state.Payments {
+		state.Payments[i] = Payment{UserID: int64(i), ChargeID: fmt.Sprintf("old-%d", i)}
+	}
+	stateMu.Unlock()
+	last := recordPayment(9, "bronze", 12000, "")
+	s = snapshot()
+	if len(s.Payments) != maxPayments {
+		t.Errorf("ledger has %d entries, want %d", len(s.Payments), maxPayments)
+	}
+	if s.Payments[0].ChargeID != "old-1" || s.Payments[len(s.Payments)-1] != last {
+		t.Errorf("rotation kept %q … %q, want the oldest entry dropped", s.Payments[0].ChargeID, s.Payments[len(s.Payments)-1].ChargeID)
+	}
+}
+
+func TestRevenueByTier(t *testing.T) {
+	from := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	at := func(days int) int64 { return from.AddDate(0, 0, days).Unix() }
+	payments := []Payment{
+		{Tier: "gold", Amount: 25000, At: at(0)},
+		{Tier: "silver", Amount: 18000, At: at(1)},
+		{Tier: "silver", Amount: 18000, At: at(2)},
+		{Tier: "gold", Amount: 20000, At: at(3)},
+		{Tier: "bronze", Amount: 12000, At: at(6)},
+		{Tier: "gold", Amount: 25000, At: at(7)},  // at to: excluded
+		{Tier: "gold", Amount: 25000, At: at(-1)}, // before from
+	}
+
+	got, total := revenueByTier(payments, from, to)
+	want := []TierRevenue{
+		{Tier: "gold", Count: 2, Amount: 45000},
+		{Tier: "silver", Count: 2, Amount: 36000},
+		{Tier: "bronze", Count: 1, Amount: 12000},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("revenueByTier = %+v, want %+v", got, want)
+	}
+	if total != 93000 {
+		t.Errorf("total = %d, want 93000", total)
+	}
+
+	// Equal amounts fall back to the tier code.
+	got, _ = revenueByTier([]Payment{{Tier: "silver", Amount: 100, At: at(0)}, {Tier: "bronze", Amount: 100, At: at(0)}}, from, to)
+	if len(got) != 2 || got[0].Tier != "bronze" {
+		t.Errorf("tie order = %+v, want bronze first", got)
+	}
+	if got, total := revenueByTier(nil, from, to); len(got) != 0 || total != 0 {
+		t.Errorf("empty ledger: %+v, %d", got, total)
+	}
+}
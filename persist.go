@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	saveAttempts = 3
+	saveBackoff  = 200 * time.Millisecond
+)
+
+// inMemory is set by STATE_IN_MEMORY=1: the operator has chosen to run
+// without a writable state file, so saves are skipped rather than failing.
+var inMemory bool
+
+// readOnly is set while the state file can't be written. Anything that
+// changes state (see changesState) is refused until a save succeeds again,
+// so users aren't told about changes that would be lost on restart.
+var readOnly atomic.Bool
+
+// writeState writes b to statePath, retrying with backoff. Once in read-only
+// mode a single attempt is made, so every update doesn't wait on a broken
+// disk; watchPersistence keeps retrying in the background.
+func writeState(b []byte) error {
+	if inMemory {
+		return nil
+	}
+	attempts := saveAttempts
+	if readOnly.Load() {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(saveBackoff << (i - 1))
+		}
+		if err = writeFileAtomic(statePath, b); err == nil {
+			if readOnly.Swap(false) {
+				log.Printf("state saved again, leaving read-only mode")
+			}
+			return nil
+		}
+	}
+	if !readOnly.Swap(true) {
+		log.Printf("!!! cannot save state (%v), entering read-only mode", err)
+	}
+	return err
+}
+
+// writeFileAtomic writes to a temporary file and renames it over path, so a
+// failed write (e.g. disk full) leaves the previous state file intact.
+func writeFileAtomic(path string, b []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// probeWritable checks at startup that state can be saved in dir by going
+// through the same write-and-rename steps as writeFileAtomic on a scratch
+// file. Without it a read-only mount would only show up as failed saves
+// after users had already been told their changes went through.
+func probeWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	defer os.Remove(name)
+	_, err = f.Write([]byte("{}"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	renamed := name + ".renamed"
+	if err := os.Rename(name, renamed); err != nil {
+		return err
+	}
+	return os.Remove(renamed)
+}
+
+// writeCallbacks and writeCommands change state and tell the user it
+// worked; in read-only mode they are refused, since the change would be lost
+// on restart. Callbacks are matched by prefix.
+var (
+	writeCallbacks = []string{
+		"slot_", "confirm_", "unhold_", "repeat_", "rcancel_", "ccancelok_",
+		"mbook_", "subscribe_", "bcancel_", "bmoveto_", "pay_", "offer_",
+		"offerno_",
+	}
+	writeCommands = map[string]bool{
+		"cancel": true, "redeem": true, "checkin": true,
+		"blackout": true, "resetuser": true, "grant": true, "edittrainer": true,
+		"deltrainer": true, "setrole": true, "ban": true, "unban": true,
+		"trainerstatus": true, "promo": true, "setlimit": true,
+		// /profile assigns a referral code on first use.
+		"profile": true,
+	}
+)
+
+const readOnlyText = "Сохранение временно недоступно, изменения не приняты. Попробуйте позже."
+
+// changesState reports whether handling update would change saved state:
+// a write callback or command, /start from a referral link, a shared phone
+// number, or the reply to a booking note prompt.
+func changesState(update telegram.Update) bool {
+	if cq := update.CallbackQuery; cq != nil {
+		for _, p := range writeCallbacks {
+			if strings.HasPrefix(cq.Data, p) {
+				return true
+			}
+		}
+		return false
+	}
+	msg := update.Message
+	if msg == nil || msg.From == nil {
+		return false
+	}
+	if msg.IsCommand() {
+		if msg.Command() == "start" {
+			// A referral link credits the referrer.
+			_, ok := parseReferralPayload(msg.CommandArguments())
+			return ok
+		}
+		return writeCommands[msg.Command()]
+	}
+	if msg.Contact != nil {
+		return true
+	}
+	st, ok := getConversation(msg.From.ID)
+	return ok && st.Step == stepBookingNote && !isMenuText(msg.Text)
+}
+
+// refuseReadOnly tells the user their action was not applied.
+func refuseReadOnly(bot Sender, update telegram.Update) {
+	if cq := update.CallbackQuery; cq != nil {
+		_ = answerCallback(bot, cq.ID, readOnlyText, true)
+		return
+	}
+	_ = replyError(bot, update.Message.Chat.ID, readOnlyText)
+}
+
+// watchPersistence alerts the staff chat when the bot enters or leaves
+// read-only mode and retries the save every interval while it is read-only.
+func watchPersistence(bot Sender, interval time.Duration) {
+	wasReadOnly := false
+	for range time.Tick(interval) {
+		if readOnly.Load() {
+			_ = saveState()
+		}
+		ro := readOnly.Load()
+		if ro == wasReadOnly {
+			continue
+		}
+		wasReadOnly = ro
+		if staffChat == 0 {
+			continue
+		}
+		text := "✅ Состояние снова сохраняется, запись и оплата работают."
+		if ro {
+			text = "⚠️ Не удаётся сохранить состояние на диск. Бот работает в режиме только для чтения: новые записи и оплата отключены."
+		}
+		_ = notify(bot, telegram.NewMessage(staffChat, text))
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWriteFailureRefusesChanges(t *testing.T) {
+	setupState(t)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Name: "Test", HasPaid: true, Tier: "gold", PaidUntil: now().AddDate(0, 1, 0).Unix()}
+	state.Users[8] = &User{ID: 8, Name: "Referrer", ReferralCode: "FRIEND8"}
+	stateMu.Unlock()
+	b, err := bookSlot(7, 1, "09:00")
+	if err != nil {
+		t.Fatalf("book: %v", err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "gone")
+	statePath = filepath.Join(dir, "state.json")
+	if err := saveState(); err == nil {
+		t.Fatal("saveState succeeded without a directory to write to")
+	}
+	if !readOnly.Load() {
+		t.Fatal("failed save did not enter read-only mode")
+	}
+
+	bot := &fakeSender{}
+	handleUpdate(bot, callbackUpdate(7, "slot_1_10:00"))
+	handleUpdate(bot, callbackUpdate(7, fmt.Sprintf("bcancel_%d", b.ID)))
+	handleUpdate(bot, textUpdate(7, "/cancel "+b.Code))
+
+	if !slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("slot was held in read-only mode")
+	}
+	if len(userBookings(7)) != 1 {
+		t.Error("booking was cancelled in read-only mode")
+	}
+	answers := bot.callbackAnswers()
+	if len(answers) != 2 || answers[0].Text != readOnlyText || !answers[0].ShowAlert {
+		t.Errorf("callback answers = %+v, want two read-only alerts", answers)
+	}
+	if !bot.sentContaining(readOnlyText) {
+		t.Errorf("/cancel reply = %q, want the read-only notice", bot.texts())
+	}
+
+	// Read-only actions keep working.
+	handleUpdate(bot, callbackUpdate(7, "mybookings"))
+	if n := len(bot.texts()); n != 2 {
+		t.Errorf("got %d messages after mybookings, want 2", n)
+	}
+
+	bot = &fakeSender{}
+	handleUpdate(bot, textUpdate(9, "/start ref_FRIEND8"))
+	handleUpdate(bot, textUpdate(7, "/profile"))
+	s := snapshot()
+	if s.Users[8].ReferralCount != 0 || (s.Users[9] != nil && s.Users[9].ReferredBy != 0) {
+		t.Error("referral was credited in read-only mode")
+	}
+	if s.Users[7].ReferralCode != "" {
+		t.Error("/profile assigned a referral code in read-only mode")
+	}
+	if got := bot.texts(); len(got) != 2 || !strings.Contains(got[0], readOnlyText) || !strings.Contains(got[1], readOnlyText) {
+		t.Errorf("replies = %q, want two read-only notices", got)
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveState(); err != nil {
+		t.Fatalf("saveState after recovery: %v", err)
+	}
+	if readOnly.Load() {
+		t.Error("successful save did not leave read-only mode")
+	}
+}
+
+func TestWriteFileAtomicKeepsOldFileOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	if err := writeFileAtomic(path, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	// A directory where the temp file should go makes the write fail.
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new")); err == nil {
+		t.Fatal("write succeeded")
+	}
+	got, err := os.ReadFile(path)
+	if err != nil || string(got) != "old" {
+		t.Errorf("state file = %q, %v; want the old content", got, err)
+	}
+}
+
+func TestTruncatedStateIsBackedUp(t *testing.T) {
+	setupState(t)
+	data := []byte(`{"schema_version": 3, "users": {"7": {"id": 7, "na`)
+	if err := os.WriteFile(statePath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("STRICT_STATE", "1")
+	if err := loadState(); err == nil {
+		t.Fatal("STRICT_STATE=1 loaded a truncated file")
+	}
+	if got, _ := os.ReadFile(statePath); string(got) != string(data) {
+		t.Fatal("STRICT_STATE=1 touched the corrupt file")
+	}
+
+	t.Setenv("STRICT_STATE", "")
+	if err := loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	s := snapshot()
+	if len(s.Users) != 0 || len(s.Trainers) != len(defaultTrainers()) {
+		t.Errorf("state after a corrupt file: %d users, %d trainers; want defaults", len(s.Users), len(s.Trainers))
+	}
+	backup := fmt.Sprintf("%s.bad.%d", statePath, now().Unix())
+	if got, err := os.ReadFile(backup); err != nil || string(got) != string(data) {
+		t.Errorf("backup %s = %q, %v; want the corrupt content", backup, got, err)
+	}
+	if _, err := os.Stat(statePath); err != nil {
+		t.Errorf("fresh state was not saved: %v", err)
+	}
+}
+
+func TestProbeWritable(t *testing.T) {
+	dir := t.TempDir()
+	if err := probeWritable(dir); err != nil {
+		t.Fatalf("probe of a writable directory: %v", err)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 0 {
+		t.Errorf("probe left %d files behind", len(left))
+	}
+	if err := probeWritable(filepath.Join(dir, "missing")); err == nil {
+		t.Error("probe of a missing directory succeeded")
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores directory permissions")
+	}
+	ro := filepath.Join(dir, "ro")
+	if err := os.Mkdir(ro, 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := probeWritable(ro); err == nil {
+		t.Error("probe of a read-only directory succeeded")
+	}
+}
+
+func TestInMemoryModeSkipsWrites(t *testing.T) {
+	setupState(t)
+	defer func() { inMemory = false }()
+	inMemory = true
+	if err := saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+		t.Errorf("in-memory mode wrote %s", statePath)
+	}
+	if readOnly.Load() {
+		t.Error("in-memory mode entered read-only mode")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleProfile shows the user's subscription and referral stats.
+func handleProfile(bot Sender, msg *telegram.Message) {
+	stateMu.Lock()
+	u, ok := state.Users[msg.From.ID]
+	var user User
+	var code string
+	if ok {
+		code = referralCodeLocked(u)
+		user = *u
+	}
+	stateMu.Unlock()
+	if !ok {
+		_ = replyError(bot, msg.Chat.ID, "Профиль не найден. Отправьте /start.")
+		return
+	}
+	_ = saveState()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "👤 %s\n", user.Name)
+	fmt.Fprintf(&b, "Абонемент: %s.\n", subscriptionStatus(user))
+	fmt.Fprintf(&b, "Лимит записей к одному тренеру: %d.\n", user.bookingLimit())
+	if noShowTracking && user.NoShowCount > 0 {
+		fmt.Fprintf(&b, "Пропущено тренировок: %d.\n", user.NoShowCount)
+	}
+	if user.Discount > 0 {
+		fmt.Fprintf(&b, "Скидка на следующую оплату: %d%%.\n", user.Discount)
+	}
+	if user.BonusDays > 0 {
+		fmt.Fprintf(&b, "Бонусные дни: %d — добавятся к следующему абонементу.\n", user.BonusDays)
+	}
+	fmt.Fprintf(&b, "\n🎁 Приглашайте друзей: %s\n", referralLink(code))
+	fmt.Fprintf(&b, "Приглашено: %d. За каждые %d приглашённых — %s абонемента в подарок.",
+		user.ReferralCount, referralsPerReward, daysText(referralRewardDays))
+	if left := referralsPerReward - user.ReferralCount%referralsPerReward; user.ReferralCount > 0 {
+		fmt.Fprintf(&b, "\nДо следующего подарка: %d.", left)
+	}
+	m := telegram.NewMessage(msg.Chat.ID, b.String())
+	m.ReplyMarkup = mainMenuKeyboard(userLang(msg.From.ID))
+	_ = send(bot, m)
+}
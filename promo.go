@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Promo code types.
+const (
+	promoDays    = "days"    // Value free days of subscription
+	promoPercent = "percent" // Value percent off the next payment
+)
+
+// PromoCode is an admin-issued code redeemed with /redeem.
+type PromoCode struct {
+	Code  string `json:"code"`
+	Type  string `json:"type"`
+	Value int    `json:"value"`
+	// MaxUses of zero means unlimited; ExpiresAt of zero means never.
+	MaxUses   int     `json:"max_uses"`
+	Used      int     `json:"used"`
+	ExpiresAt int64   `json:"expires_at"`
+	UsedBy    []int64 `json:"used_by"`
+}
+
+func (p PromoCode) describe() string {
+	if p.Type == promoDays {
+		return daysText(p.Value) + " абонемента"
+	}
+	return fmt.Sprintf("скидка %d%% на следующую оплату", p.Value)
+}
+
+func findPromoLocked(code string) *PromoCode {
+	for i := range state.Promos {
+		if state.Promos[i].Code == code {
+			return &state.Promos[i]
+		}
+	}
+	return nil
+}
+
+// redeemPromo applies code to the user: free days extend the subscription
+// (or wait for the next purchase), a percentage becomes the user's discount
+// on their next payment.
+func redeemPromo(userID int64, code string) (PromoCode, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok {
+		return PromoCode{}, errUserNotFound
+	}
+	p := findPromoLocked(code)
+	switch {
+	case p == nil:
+		return PromoCode{}, fmt.Errorf("промокод не найден")
+	case p.ExpiresAt != 0 && now().Unix() >= p.ExpiresAt:
+		return PromoCode{}, fmt.Errorf("срок действия промокода истёк")
+	case p.MaxUses != 0 && p.Used >= p.MaxUses:
+		return PromoCode{}, fmt.Errorf("промокод больше не действует")
+	case slices.Contains(p.UsedBy, userID):
+		return PromoCode{}, fmt.Errorf("вы уже использовали этот промокод")
+	}
+	if p.Type == promoDays {
+		addBonusDaysLocked(u, p.Value)
+	} else {
+		u.Discount = max(u.Discount, p.Value)
+	}
+	p.Used++
+	p.UsedBy = append(p.UsedBy, userID)
+	return *p, nil
+}
+
+// applyDiscount charges the user's pending discount to the subscription they
+// just paid for and returns the price paid and the discount used.
+func applyDiscount(userID int64) (price, discount int) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok {
+		return 0, 0
+	}
+	t, _ := findTier(u.Tier)
+	discount = u.Discount
+	u.PaidPrice = t.Price * (100 - discount) / 100
+	u.Discount = 0
+	return u.PaidPrice, discount
+}
+
+func handleRedeem(bot Sender, msg *telegram.Message) {
+	code := strings.TrimSpace(msg.CommandArguments())
+	if code == "" {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /redeem <промокод>")
+		return
+	}
+	p, err := redeemPromo(msg.From.ID, code)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось применить промокод: "+err.Error())
+		return
+	}
+	_ = saveState()
+	m := telegram.NewMessage(msg.Chat.ID, "🎟 Промокод применён: "+p.describe()+".")
+	m.ReplyMarkup = mainMenuKeyboard(userLang(msg.From.ID))
+	_ = send(bot, m)
+}
+
+// handlePromo manages promo codes:
+//
+//	/promo add <код> days|percent <значение> [макс. использований] [ГГГГ-ММ-ДД]
+//	/promo del <код>
+//	/promo list
+func handlePromo(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	const usage = "Использование:\n/promo add <код> days|percent <значение> [макс. использований] [действует до ГГГГ-ММ-ДД]\n/promo del <код>\n/promo list"
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		_ = replyError(bot, msg.Chat.ID, usage)
+		return
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		s := snapshot()
+		if len(s.Promos) == 0 {
+			_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Промокодов нет."))
+			return
+		}
+		var sb strings.Builder
+		sb.WriteString("Промокоды:")
+		for _, p := range s.Promos {
+			fmt.Fprintf(&sb, "\n• %s — %s, использован %d", p.Code, p.describe(), p.Used)
+			if p.MaxUses != 0 {
+				fmt.Fprintf(&sb, " из %d", p.MaxUses)
+			}
+			if p.ExpiresAt != 0 {
+				fmt.Fprintf(&sb, ", до %s", time.Unix(p.ExpiresAt, 0).In(gymLoc).AddDate(0, 0, -1).Format(dateLayout))
+			}
+		}
+		_ = sendLong(bot, msg.Chat.ID, sb.String())
+	case args[0] == "del" && len(args) == 2:
+		code := strings.ToUpper(args[1])
+		stateMu.Lock()
+		n := len(state.Promos)
+		state.Promos = slices.DeleteFunc(state.Promos, func(p PromoCode) bool { return p.Code == code })
+		removed := n != len(state.Promos)
+		stateMu.Unlock()
+		if !removed {
+			_ = replyError(bot, msg.Chat.ID, "Промокод не найден.")
+			return
+		}
+		recordAdminAction(msg.From.ID, "promo del", code)
+		_ = saveState()
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Промокод "+code+" удалён."))
+	case args[0] == "add" && len(args) >= 4 && len(args) <= 6:
+		p, err := parsePromo(args[1:])
+		if err != nil {
+			_ = replyError(bot, msg.Chat.ID, err.Error())
+			return
+		}
+		stateMu.Lock()
+		exists := findPromoLocked(p.Code) != nil
+		if !exists {
+			state.Promos = append(state.Promos, p)
+		}
+		stateMu.Unlock()
+		if exists {
+			_ = replyError(bot, msg.Chat.ID, "Такой промокод уже есть.")
+			return
+		}
+		recordAdminAction(msg.From.ID, "promo add", p.Code)
+		_ = saveState()
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Промокод %s добавлен: %s.", p.Code, p.describe())))
+	default:
+		_ = replyError(bot, msg.Chat.ID, usage)
+	}
+}
+
+// parsePromo reads "<code> <type> <value> [max uses] [expiry date]".
+func parsePromo(args []string) (PromoCode, error) {
+	p := PromoCode{Code: strings.ToUpper(args[0]), Type: args[1]}
+	value, err := strconv.Atoi(args[2])
+	switch {
+	case p.Type != promoDays && p.Type != promoPercent:
+		return p, fmt.Errorf("тип промокода: days или percent")
+	case err != nil || value < 1:
+		return p, fmt.Errorf("значение должно быть положительным числом")
+	case p.Type == promoPercent && value > 100:
+		return p, fmt.Errorf("скидка не может быть больше 100%%")
+	}
+	p.Value = value
+	if len(args) > 3 {
+		n, err := strconv.Atoi(args[3])
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("число использований должно быть неотрицательным, 0 — без ограничений")
+		}
+		p.MaxUses = n
+	}
+	if len(args) > 4 {
+		d, err := time.ParseInLocation(dateLayout, args[4], gymLoc)
+		if err != nil {
+			return p, fmt.Errorf("неверная дата, ожидается формат ГГГГ-ММ-ДД")
+		}
+		// The code works through the whole of the given day.
+		p.ExpiresAt = d.AddDate(0, 0, 1).Unix()
+	}
+	return p, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestPromoDescribeDeclinesDays(t *testing.T) {
+	for _, c := range []struct {
+		days int
+		want string
+	}{
+		{1, "1 день абонемента"},
+		{3, "3 дня абонемента"},
+		{7, "7 дней абонемента"},
+		{11, "11 дней абонемента"},
+		{21, "21 день абонемента"},
+	} {
+		p := PromoCode{Type: promoDays, Value: c.days}
+		if got := p.describe(); got != c.want {
+			t.Errorf("describe(%d days) = %q, want %q", c.days, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxRecurringWeeks caps RECURRING_WEEKS, the number of weeks a weekly
+// series books ahead.
+const maxRecurringWeeks = 12
+
+var recurringWeeks = 4
+
+func loadRecurringConfig() {
+	v := os.Getenv("RECURRING_WEEKS")
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxRecurringWeeks {
+		configProblem("RECURRING_WEEKS: expected 1..%d, got %q", maxRecurringWeeks, v)
+		return
+	}
+	recurringWeeks = n
+}
+
+// upcomingUserBookings returns the user's bookings from today on.
+func upcomingUserBookings(userID int64) []Booking {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	date := today()
+	var res []Booking
+	for _, b := range state.Bookings {
+		if b.UserID == userID && b.Date >= date {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// weeklyResult is what happened to one week of a series.
+type weeklyResult struct {
+	Date    string
+	Booking Booking
+	Err     error
+}
+
+// checkFutureSlotLocked reports why slot on date can't be booked with t for
+// a weekly series. Callers must hold stateMu.
+func checkFutureSlotLocked(t Trainer, date, slot string) error {
+	if err := t.bookable(); err != nil {
+		return err
+	}
+	if err := checkBookingHorizon(date); err != nil {
+		return err
+	}
+	if isBlackout(t, date) {
+		return fmt.Errorf("тренер не работает в этот день.")
+	}
+	if !slices.Contains(daySlotsLocked(t, date), slot) {
+		return errSlotTaken
+	}
+	return checkTrainerDailyCap(t, date, 0)
+}
+
+// bookWeekly repeats the user's booking on the same weekday and time for the
+// next recurringWeeks weeks. Weeks that can't be booked are skipped; once the
+// user's booking limit is reached the rest are skipped too. Weeks after the
+// subscription ends are not booked.
+func bookWeekly(userID int64, bookingID int) ([]weeklyResult, error) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	pos := -1
+	for i, b := range state.Bookings {
+		if b.ID == bookingID && b.UserID == userID {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		return nil, fmt.Errorf("запись не найдена")
+	}
+	base := state.Bookings[pos]
+	if base.RecurrenceID != 0 {
+		return nil, fmt.Errorf("эта запись уже повторяется еженедельно")
+	}
+	var tr *Trainer
+	for i := range state.Trainers {
+		if state.Trainers[i].ID == base.Trainer {
+			tr = &state.Trainers[i]
+		}
+	}
+	if tr == nil {
+		return nil, fmt.Errorf("тренер не найден")
+	}
+	day, err := time.ParseInLocation(dateLayout, base.Date, gymLoc)
+	if err != nil {
+		return nil, err
+	}
+	var paidUntil int64
+	if u, ok := state.Users[userID]; ok {
+		paidUntil = u.PaidUntil
+	}
+
+	state.Bookings[pos].RecurrenceID = base.ID
+	var res []weeklyResult
+	var stop error
+	for k := 1; k <= recurringWeeks; k++ {
+		d := day.AddDate(0, 0, 7*k)
+		r := weeklyResult{Date: d.Format(dateLayout)}
+		switch {
+		case stop != nil:
+			r.Err = stop
+		case paidUntil != 0 && d.Unix() >= paidUntil:
+			r.Err = fmt.Errorf("абонемент закончится раньше")
+		default:
+			if err := checkUserLimits(userID, base.Trainer); err != nil {
+				stop, r.Err = err, err
+				break
+			}
+			if err := checkFutureSlotLocked(*tr, r.Date, base.TimeSlot); err != nil {
+				r.Err = err
+				break
+			}
+			if err := checkUserOverlap(userID, r.Date, base.TimeSlot, 0); err != nil {
+				r.Err = err
+				break
+			}
+			state.NextBookingID++
+			r.Booking = Booking{
+				ID:           state.NextBookingID,
+				UserID:       userID,
+				Trainer:      base.Trainer,
+				TimeSlot:     base.TimeSlot,
+				Date:         r.Date,
+				BookedAt:     now().Unix(),
+				Code:         newBookingCode(state.Bookings),
+				Note:         base.Note,
+				RecurrenceID: base.ID,
+			}
+			state.Bookings = append(state.Bookings, r.Booking)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// cancelSeries cancels the upcoming bookings of the user's weekly series.
+func cancelSeries(userID int64, seriesID int) ([]Booking, []int64) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	var ids []int
+	for _, b := range state.Bookings {
+		if b.UserID == userID && b.RecurrenceID == seriesID {
+			ids = append(ids, b.ID)
+		}
+	}
+	var cancelled []Booking
+	var notify []int64
+	for _, id := range ids {
+		b, n, err := cancelBookingLocked(userID, id)
+		if err != nil {
+			continue
+		}
+		cancelled = append(cancelled, b)
+		notify = append(notify, n...)
+	}
+	return cancelled, notify
+}
+
+func handleRepeatWeekly(bot Sender, cq *telegram.CallbackQuery, bookingID int) {
+	chatID := cq.Message.Chat.ID
+	results, err := bookWeekly(cq.From.ID, bookingID)
+	if err != nil {
+		_ = replyError(bot, chatID, "Не удалось повторить запись: "+err.Error())
+		return
+	}
+	_ = saveState()
+	refreshKeyboard(bot, cq.Message, telegram.NewInlineKeyboardMarkup())
+
+	var sb strings.Builder
+	booked := 0
+	for _, r := range results {
+		day := r.Date
+		if d, err := time.ParseInLocation(dateLayout, r.Date, gymLoc); err == nil {
+			day = d.Format("02.01")
+		}
+		if r.Err != nil {
+			fmt.Fprintf(&sb, "\n✖️ %s — %s", day, strings.TrimSuffix(r.Err.Error(), "."))
+			continue
+		}
+		booked++
+		metricBookings.Add(1)
+		fmt.Fprintf(&sb, "\n✅ %s %s, код %s", day, r.Booking.TimeSlot, r.Booking.Code)
+		notifyStaffBooking(bot, r.Booking, "🆕 Новая запись (еженедельно)")
+	}
+	m := telegram.NewMessage(chatID, fmt.Sprintf("Еженедельные записи: %d из %d.%s", booked, len(results), sb.String()))
+	m.ReplyMarkup = telegram.NewInlineKeyboardMarkup(telegram.NewInlineKeyboardRow(
+		telegram.NewInlineKeyboardButtonData("❌ Отменить серию", fmt.Sprintf("rcancel_%d", bookingID)),
+	))
+	_ = send(bot, m)
+}
+
+func handleCancelSeries(bot Sender, cq *telegram.CallbackQuery, seriesID int) {
+	chatID := cq.Message.Chat.ID
+	cancelled, notify := cancelSeries(cq.From.ID, seriesID)
+	if len(cancelled) == 0 {
+		_ = replyError(bot, chatID, "Записи серии не найдены.")
+		return
+	}
+	_ = saveState()
+	refreshKeyboard(bot, cq.Message, telegram.NewInlineKeyboardMarkup())
+	for _, b := range cancelled {
+		notifyStaffBooking(bot, b, "❌ Запись отменена (серия)")
+	}
+	notifySubscribers(bot, notify, cancelled[0].Trainer, cancelled[0].TimeSlot)
+	m := telegram.NewMessage(chatID, fmt.Sprintf("Серия отменена, снято записей: %d.", len(cancelled)))
+	m.ReplyMarkup = mainMenuKeyboard(userLang(cq.From.ID))
+	_ = send(bot, m)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Every referralsPerReward users joining through someone's invite earn that
+// user referralRewardDays of subscription. Overridden by
+// REFERRAL_REWARD_EVERY and REFERRAL_REWARD_DAYS.
+var (
+	referralsPerReward = 3
+	referralRewardDays = 7
+)
+
+// botUsername builds invite links. It is set once the bot is authorized.
+var botUsername string
+
+func loadReferralConfig() {
+	if v := os.Getenv("REFERRAL_REWARD_EVERY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			referralsPerReward = n
+		} else {
+			configProblem("REFERRAL_REWARD_EVERY: expected a positive number, got %q", v)
+		}
+	}
+	if v := os.Getenv("REFERRAL_REWARD_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			referralRewardDays = n
+		} else {
+			configProblem("REFERRAL_REWARD_DAYS: expected a positive number of days, got %q", v)
+		}
+	}
+}
+
+// parseReferralPayload extracts the code from a "/start ref_<code>" deep
+// link payload.
+func parseReferralPayload(payload string) (string, bool) {
+	code, ok := strings.CutPrefix(strings.TrimSpace(payload), "ref_")
+	if !ok || code == "" {
+		return "", false
+	}
+	return strings.ToUpper(code), true
+}
+
+// referralCodeLocked returns u's referral code, assigning one on first use.
+// Callers must hold stateMu.
+func referralCodeLocked(u *User) string {
+	if u.ReferralCode != "" {
+		return u.ReferralCode
+	}
+	buf := make([]byte, 6)
+	for {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		for i := range buf {
+			buf[i] = bookingCodeAlphabet[int(buf[i])%len(bookingCodeAlphabet)]
+		}
+		if referrerByCodeLocked(string(buf)) == nil {
+			u.ReferralCode = string(buf)
+			return u.ReferralCode
+		}
+	}
+}
+
+func referrerByCodeLocked(code string) *User {
+	for _, u := range state.Users {
+		if u.ReferralCode == code {
+			return u
+		}
+	}
+	return nil
+}
+
+// referralLink is the invite link for code, or just the /start command when
+// the bot's username is unknown.
+func referralLink(code string) string {
+	if botUsername == "" {
+		return "/start ref_" + code
+	}
+	return "https://t.me/" + botUsername + "?start=ref_" + code
+}
+
+// creditReferral records that newUserID joined with the given code. Only a
+// user's first /start counts, each user credits at most one referrer and
+// nobody can refer themselves. It returns the referrer and whether this
+// referral earned them a reward.
+func creditReferral(newUserID int64, code string) (referrer User, rewarded, ok bool) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, found := state.Users[newUserID]
+	if !found || u.OnboardedAt != 0 || u.ReferredBy != 0 {
+		return User{}, false, false
+	}
+	ref := referrerByCodeLocked(code)
+	if ref == nil || ref.ID == newUserID {
+		return User{}, false, false
+	}
+	u.ReferredBy = ref.ID
+	ref.ReferralCount++
+	if ref.ReferralCount%referralsPerReward == 0 {
+		addBonusDaysLocked(ref, referralRewardDays)
+		rewarded = true
+	}
+	return *ref, rewarded, true
+}
+
+// addBonusDaysLocked extends a running subscription by days, or banks them
+// for the next purchase when there is nothing to extend.
+func addBonusDaysLocked(u *User, days int) {
+	if u.IsActive() && u.PaidUntil != 0 {
+		u.PaidUntil = time.Unix(u.PaidUntil, 0).AddDate(0, 0, days).Unix()
+		return
+	}
+	u.BonusDays += days
+}
+
+// notifyReferrer tells ref that someone joined through their invite.
+func notifyReferrer(bot Sender, ref User, rewarded bool) {
+	text := fmt.Sprintf("🎉 По вашему приглашению присоединился новый пользователь! Всего приглашено: %d.", ref.ReferralCount)
+	if rewarded {
+		text += fmt.Sprintf("\nВ подарок — %s абонемента.", daysText(referralRewardDays))
+	}
+	_ = notify(bot, telegram.NewMessage(chatIDFor(ref.ID), text))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// reminderDays lists how many days before PaidUntil a renewal reminder is
+// sent. Overridden by REMINDER_DAYS, e.g. "3,1".
+var reminderDays = []int{3, 1}
+
+func loadReminderDays() {
+	v := os.Getenv("REMINDER_DAYS")
+	if v == "" {
+		return
+	}
+	var days []int
+	for _, f := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 {
+			configProblem("REMINDER_DAYS: expected positive day counts, got %q", v)
+			return
+		}
+		days = append(days, n)
+	}
+	reminderDays = days
+}
+
+// renewalReminder is one reminder that is due to be sent.
+type renewalReminder struct {
+	UserID    int64
+	Days      int
+	PaidUntil int64
+}
+
+// dueReminders returns the reminders whose offset has been reached for
+// active subscriptions and that were not sent yet for the current PaidUntil.
+func dueReminders(at time.Time) []renewalReminder {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	var due []renewalReminder
+	for _, u := range state.Users {
+		if !u.IsActive() || u.PaidUntil == 0 || u.Blocked {
+			continue
+		}
+		left := time.Unix(u.PaidUntil, 0).Sub(at)
+		// Only the closest offset that has been reached is sent, so a user who
+		// subscribed two days before expiry does not get the 3-day reminder too.
+		best := 0
+		for _, d := range reminderDays {
+			if left <= time.Duration(d)*24*time.Hour && (best == 0 || d < best) {
+				best = d
+			}
+		}
+		if best == 0 {
+			continue
+		}
+		if u.RemindedFor == u.PaidUntil && slices.Contains(u.RemindedDays, best) {
+			continue
+		}
+		due = append(due, renewalReminder{UserID: u.ID, Days: best, PaidUntil: u.PaidUntil})
+	}
+	return due
+}
+
+// markReminded records that r was sent. Reminders recorded for an older
+// PaidUntil are dropped, so a renewal starts a fresh cycle.
+func markReminded(r renewalReminder) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[r.UserID]
+	if !ok {
+		return
+	}
+	if u.RemindedFor != r.PaidUntil {
+		u.RemindedFor = r.PaidUntil
+		u.RemindedDays = nil
+	}
+	for _, d := range reminderDays {
+		if d >= r.Days && !slices.Contains(u.RemindedDays, d) {
+			u.RemindedDays = append(u.RemindedDays, d)
+		}
+	}
+}
+
+func markBlocked(userID int64) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if u, ok := state.Users[userID]; ok {
+		u.Blocked = true
+	}
+}
+
+// isBlockedError reports whether Telegram refused delivery because the user
+// blocked the bot or deleted the chat.
+func isBlockedError(err error) bool {
+	var tgErr *telegram.Error
+	return errors.As(err, &tgErr) && tgErr.Code == http.StatusForbidden
+}
+
+func sendRenewalReminders(bot Sender) int {
+	sent := 0
+	for _, r := range dueReminders(now()) {
+		until := formatDate(time.Unix(r.PaidUntil, 0).In(gymLoc), userLang(r.UserID))
+		m := telegram.NewMessage(chatIDFor(r.UserID), fmt.Sprintf("Ваш абонемент действует до %s. Продлите его заранее, чтобы не потерять доступ к записи:", until))
+		m.ReplyMarkup = pricingKeyboard()
+		if _, err := notifySync(bot, m); err != nil {
+			if isBlockedError(err) {
+				markBlocked(r.UserID)
+			}
+			continue
+		}
+		markReminded(r)
+		sent++
+	}
+	return sent
+}
+
+// sweepReminders checks for due renewal reminders every interval. It runs
+// more often than once a day so a restart never skips a day; already sent
+// reminders are recorded on the user and not repeated.
+func sweepReminders(bot Sender, interval time.Duration) {
+	for ; ; time.Sleep(interval) {
+		n := sendRenewalReminders(bot)
+		if n == 0 {
+			continue
+		}
+		log.Printf("sent %d renewal reminders", n)
+		if err := saveState(); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+}
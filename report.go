@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// heatmapBarWidth caps the longest bar in /heatmap.
+const heatmapBarWidth = 20
+
+// HourCount is the number of bookings starting at a given time of day.
+type HourCount struct {
+	TimeSlot string
+	Count    int
+}
+
+// bookingHeatmap counts bookings per time slot across all trainers and dates,
+// sorted by time of day.
+func bookingHeatmap(bookings []Booking) []HourCount {
+	counts := map[string]int{}
+	for _, b := range bookings {
+		counts[b.TimeSlot]++
+	}
+	res := make([]HourCount, 0, len(counts))
+	for slot, n := range counts {
+		res = append(res, HourCount{TimeSlot: slot, Count: n})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].TimeSlot < res[j].TimeSlot })
+	return res
+}
+
+// renderHeatmap draws one bar per row, scaled so the busiest slot gets
+// heatmapBarWidth blocks.
+func renderHeatmap(rows []HourCount) string {
+	if len(rows) == 0 {
+		return "Записей пока нет."
+	}
+	max := 0
+	for _, r := range rows {
+		if r.Count > max {
+			max = r.Count
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("Загрузка по времени:\n")
+	for _, r := range rows {
+		width := r.Count * heatmapBarWidth / max
+		if width == 0 {
+			width = 1
+		}
+		fmt.Fprintf(&sb, "%s %s %d\n", r.TimeSlot, strings.Repeat("█", width), r.Count)
+	}
+	return sb.String()
+}
+
+func handleHeatmap(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	text := renderHeatmap(bookingHeatmap(snapshot().Bookings))
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, text))
+}
+
+// upcomingBookings returns the trainer's bookings starting at or after at,
+// earliest first.
+func upcomingBookings(bookings []Booking, trainerID int, at time.Time) []Booking {
+	var res []Booking
+	for _, b := range bookings {
+		if b.Trainer != trainerID {
+			continue
+		}
+		if start, err := bookingStart(b); err == nil && !start.Before(at) {
+			res = append(res, b)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Date != res[j].Date {
+			return res[i].Date < res[j].Date
+		}
+		return res[i].TimeSlot < res[j].TimeSlot
+	})
+	return res
+}
+
+// handleRoster lists who is coming to a trainer's upcoming sessions.
+func handleRoster(bot Sender, msg *telegram.Message) {
+	if !requireStaff(bot, msg) {
+		return
+	}
+	trainerID, err := strconv.Atoi(strings.TrimSpace(msg.CommandArguments()))
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /roster <id тренера>")
+		return
+	}
+	s := snapshot()
+	var tr *Trainer
+	for i := range s.Trainers {
+		if s.Trainers[i].ID == trainerID {
+			tr = &s.Trainers[i]
+		}
+	}
+	if tr == nil {
+		_ = replyError(bot, msg.Chat.ID, "Тренер не найден.")
+		return
+	}
+	bookings := upcomingBookings(s.Bookings, trainerID, now())
+	if len(bookings) == 0 {
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("У тренера %s нет предстоящих записей.", tr.Name)))
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Записи к тренеру %s:\n", tr.Name)
+	for _, b := range bookings {
+		name := fmt.Sprintf("id %d", b.UserID)
+		if u, ok := s.Users[b.UserID]; ok && u.Name != "" {
+			name = u.Name
+		}
+		fmt.Fprintf(&sb, "\n%s — %s, код %s", formatSession(b.Trainer, b.Date, b.TimeSlot), name, b.Code)
+	}
+	_ = sendLong(bot, msg.Chat.ID, sb.String())
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Role is what a user may do beyond booking. Roles are ordered: staff can do
+// everything a user can, admins everything staff can.
+type Role string
+
+const (
+	roleUser  Role = "user"
+	roleStaff Role = "staff"
+	roleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{roleUser: 0, roleStaff: 1, roleAdmin: 2}
+
+// roleOf returns the user's role. Users listed in ADMIN_IDS are always
+// admins, so a deployment can't lock itself out by demoting everyone.
+func roleOf(userID int64) Role {
+	if adminIDs[userID] {
+		return roleAdmin
+	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if u, ok := state.Users[userID]; ok && u.Role != "" {
+		return u.Role
+	}
+	return roleUser
+}
+
+// hasRole reports whether the user has role or a higher one.
+func hasRole(userID int64, role Role) bool {
+	return roleRank[roleOf(userID)] >= roleRank[role]
+}
+
+// setRole stores the user's role; roleUser clears it.
+func setRole(userID int64, role Role) error {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	u, ok := state.Users[userID]
+	if !ok {
+		return errUserNotFound
+	}
+	if role == roleUser {
+		role = ""
+	}
+	u.Role = role
+	return nil
+}
+
+// handleSetRole answers /setrole <id> <user|staff|admin>.
+func handleSetRole(bot Sender, msg *telegram.Message) {
+	if !requireAdmin(bot, msg) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 {
+		_ = replyError(bot, msg.Chat.ID, "Использование: /setrole <id пользователя> user|staff|admin")
+		return
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Неверный id пользователя.")
+		return
+	}
+	role := Role(strings.ToLower(args[1]))
+	if _, ok := roleRank[role]; !ok {
+		_ = replyError(bot, msg.Chat.ID, "Роль должна быть user, staff или admin.")
+		return
+	}
+	if adminIDs[userID] && role != roleAdmin {
+		_ = replyError(bot, msg.Chat.ID, "Этот администратор задан в ADMIN_IDS, его роль не меняется командой.")
+		return
+	}
+	if err := setRole(userID, role); err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось изменить роль: "+err.Error())
+		return
+	}
+	recordAdminAction(msg.From.ID, "setrole "+string(role), fmt.Sprintf("user %d", userID))
+	_ = saveState()
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Роль пользователя %d: %s.", userID, role)))
+}
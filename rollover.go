@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	// slotResetAt is the gym-local time, in minutes after midnight, at which
+	// the daily rollover runs. Set with SLOT_RESET_AT=HH:MM. Booking and
+	// holding don't wait for it: they roll the slots over themselves once
+	// the date has changed (see ensureTodayLocked).
+	slotResetAt = 0
+	// bookingRetentionDays is how long past bookings are kept for history.
+	bookingRetentionDays = 90
+)
+
+func loadRolloverConfig() {
+	if v := os.Getenv("SLOT_RESET_AT"); v != "" {
+		m, err := parseClock(v)
+		if err != nil {
+			configProblem("SLOT_RESET_AT: expected HH:MM, got %q", v)
+		} else {
+			slotResetAt = m
+		}
+	}
+	if v := os.Getenv("BOOKING_RETENTION_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			configProblem("BOOKING_RETENTION_DAYS: expected a positive number, got %q", v)
+		} else {
+			bookingRetentionDays = n
+		}
+	}
+}
+
+// rolloverDay makes date the day that Trainers[].Slots describe. Each
+// trainer's slots are rebuilt from the schedule minus what is already booked
+// or held for date; holds for other days are dropped and bookings older than
+// bookingRetentionDays are pruned. It does nothing if the slots already
+// belong to date, and reports whether it ran.
+func rolloverDay(date string) bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	return rolloverDayLocked(date)
+}
+
+// ensureTodayLocked rolls the slots over to today if the date has changed
+// since they were generated. Between midnight and slotResetAt the stored
+// slots are still yesterday's, and booking against them under today's date
+// could double-book or use times that don't exist today. Callers must hold
+// stateMu.
+func ensureTodayLocked() {
+	rolloverDayLocked(today())
+}
+
+// rolloverDayLocked is rolloverDay for callers that already hold stateMu.
+func rolloverDayLocked(date string) bool {
+	if state.SlotsDate == date {
+		return false
+	}
+
+	state.Holds = slices.DeleteFunc(state.Holds, func(h Hold) bool { return h.Date != date })
+
+	taken := map[int][]string{}
+	for _, b := range state.Bookings {
+		if b.Date == date {
+			taken[b.Trainer] = append(taken[b.Trainer], b.TimeSlot)
+		}
+	}
+	for _, h := range state.Holds {
+		taken[h.Trainer] = append(taken[h.Trainer], h.TimeSlot)
+	}
+	for i := range state.Trainers {
+		t := &state.Trainers[i]
+		t.Slots = slices.DeleteFunc(buildSlots(t.Schedule), func(s string) bool {
+			return slices.Contains(taken[t.ID], s)
+		})
+	}
+
+	if d, err := time.ParseInLocation(dateLayout, date, gymLoc); err == nil {
+		cutoff := d.AddDate(0, 0, -bookingRetentionDays).Format(dateLayout)
+		state.Bookings = slices.DeleteFunc(state.Bookings, func(b Booking) bool {
+			return b.Date != "" && b.Date < cutoff
+		})
+	}
+
+	state.SlotsDate = date
+	return true
+}
+
+// nextRollover returns the first slotResetAt after t in the gym's timezone.
+func nextRollover(t time.Time) time.Time {
+	t = t.In(gymLoc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), slotResetAt/60, slotResetAt%60, 0, 0, gymLoc)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// runDailyRollover sleeps until each slotResetAt and rolls the slots over to
+// the current day.
+func runDailyRollover() {
+	for {
+		time.Sleep(time.Until(nextRollover(now())))
+		if !rolloverDay(today()) {
+			continue
+		}
+		log.Printf("slots regenerated for %s", today())
+		if err := saveState(); err != nil {
+			log.Printf("save state: %v", err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBookingBeforeSlotReset(t *testing.T) {
+	setupState(t)
+	defer func(m int) { slotResetAt = m }(slotResetAt)
+	slotResetAt = 6 * 60
+
+	// Yesterday's slots are still stored: 10:00 was booked yesterday, and
+	// a weekly series already holds 11:00 today.
+	yesterday := now().AddDate(0, 0, -1).Format(dateLayout)
+	stateMu.Lock()
+	state.SlotsDate = yesterday
+	state.Trainers[0].Slots = slices.DeleteFunc(state.Trainers[0].Slots, func(s string) bool { return s == "10:00" })
+	state.Bookings = append(state.Bookings,
+		Booking{ID: 1, UserID: 2, Trainer: 1, TimeSlot: "10:00", Date: yesterday},
+		Booking{ID: 2, UserID: 3, Trainer: 1, TimeSlot: "11:00", Date: today()},
+	)
+	state.NextBookingID = 2
+	stateMu.Unlock()
+
+	setNow(t, testClock.Add(-5*time.Hour-30*time.Minute)) // 00:30, before the reset
+	if _, err := bookSlot(4, 1, "11:00"); !errors.Is(err, errSlotTaken) {
+		t.Errorf("booking today's taken 11:00: got %v, want errSlotTaken", err)
+	}
+	if err := holdSlot(5, 1, "11:00"); err == nil {
+		t.Error("held today's taken 11:00")
+	}
+	if _, err := bookSlot(4, 1, "10:00"); err != nil {
+		t.Errorf("booking 10:00, free today: %v", err)
+	}
+	if s := snapshot(); s.SlotsDate != today() {
+		t.Errorf("slots date = %s, want %s", s.SlotsDate, today())
+	}
+	if slices.Contains(trainerSlots(t, 1), "11:00") {
+		t.Error("11:00 is on sale although it is booked today")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuildSlots(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		spec SlotSpec
+		want []string
+	}{
+		{"hourly", SlotSpec{Start: "09:00", End: "12:00", Interval: 60}, []string{"09:00", "10:00", "11:00"}},
+		{"last slot starts before end", SlotSpec{Start: "10:00", End: "13:00", Interval: 90}, []string{"10:00", "11:30"}},
+		{"break", SlotSpec{Start: "08:00", End: "16:00", Interval: 60, Exclude: []TimeRange{{From: "12:00", To: "14:00"}}},
+			[]string{"08:00", "09:00", "10:00", "11:00", "14:00", "15:00"}},
+		{"break start inside a slot", SlotSpec{Start: "09:00", End: "12:00", Interval: 60, Exclude: []TimeRange{{From: "10:30", To: "11:00"}}},
+			[]string{"09:00", "10:00", "11:00"}},
+		{"two breaks", SlotSpec{Start: "09:00", End: "13:00", Interval: 30, Exclude: []TimeRange{{From: "09:30", To: "10:30"}, {From: "11:00", To: "12:30"}}},
+			[]string{"09:00", "10:30", "12:30"}},
+	} {
+		if got := buildSlots(c.spec); !slices.Equal(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestInvalidSlotSpecs(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		spec SlotSpec
+	}{
+		{"bad start", SlotSpec{Start: "9am", End: "12:00", Interval: 60}},
+		{"bad end", SlotSpec{Start: "09:00", End: "25:00", Interval: 60}},
+		{"end before start", SlotSpec{Start: "12:00", End: "09:00", Interval: 60}},
+		{"empty day", SlotSpec{Start: "09:00", End: "09:00", Interval: 60}},
+		{"zero interval", SlotSpec{Start: "09:00", End: "12:00"}},
+		{"reversed break", SlotSpec{Start: "09:00", End: "12:00", Interval: 60, Exclude: []TimeRange{{From: "11:00", To: "10:00"}}}},
+		{"overlapping breaks", SlotSpec{Start: "09:00", End: "18:00", Interval: 60, Exclude: []TimeRange{{From: "13:00", To: "15:00"}, {From: "12:00", To: "14:00"}}}},
+	} {
+		if err := c.spec.validate(); err == nil {
+			t.Errorf("%s: validate accepted %+v", c.name, c.spec)
+		}
+		if got := buildSlots(c.spec); len(got) != 0 {
+			t.Errorf("%s: buildSlots = %v, want none", c.name, got)
+		}
+	}
+	for _, tr := range defaultTrainers() {
+		if err := tr.Schedule.validate(); err != nil {
+			t.Errorf("trainer %d: default schedule invalid: %v", tr.ID, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeSender is a Sender that records every call instead of talking to
+// Telegram. Send returns a message with a fresh id in the target chat.
+type fakeSender struct {
+	mu       sync.Mutex
+	sent     []telegram.Chattable
+	requests []telegram.Chattable
+	nextID   int
+}
+
+func (f *fakeSender) Send(c telegram.Chattable) (telegram.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = append(f.sent, c)
+	f.nextID++
+	return telegram.Message{MessageID: f.nextID, Chat: &telegram.Chat{ID: chatOf(c)}}, nil
+}
+
+func (f *fakeSender) Request(c telegram.Chattable) (*telegram.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, c)
+	return &telegram.APIResponse{Ok: true}, nil
+}
+
+// texts returns the text of every sent message, in order.
+func (f *fakeSender) texts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []string
+	for _, c := range f.sent {
+		switch m := c.(type) {
+		case telegram.MessageConfig:
+			res = append(res, m.Text)
+		case telegram.EditMessageTextConfig:
+			res = append(res, m.Text)
+		}
+	}
+	return res
+}
+
+// callbackAnswers returns the text of every answered callback query.
+func (f *fakeSender) callbackAnswers() []telegram.CallbackConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var res []telegram.CallbackConfig
+	for _, c := range f.requests {
+		if cb, ok := c.(telegram.CallbackConfig); ok {
+			res = append(res, cb)
+		}
+	}
+	return res
+}
+
+// sentContaining reports whether any sent message contains substr.
+func (f *fakeSender) sentContaining(substr string) bool {
+	for _, t := range f.texts() {
+		if strings.Contains(t, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// testClock is the fixed time tests run at unless they set their own.
+var testClock = time.Date(2026, 3, 10, 6, 0, 0, 0, time.UTC)
+
+// setNow replaces the bot's clock with a fixed time for the test.
+func setNow(t *testing.T, at time.Time) {
+	t.Helper()
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = time.Now })
+}
+
+// setupState gives the test a fresh state with the default trainers, saved
+// to a temporary directory, a fixed clock and no outbox, so sends are
+// delivered before the handler returns.
+func setupState(t *testing.T) {
+	t.Helper()
+	setNow(t, testClock)
+	statePath = filepath.Join(t.TempDir(), "state.json")
+	outbox = nil
+	readOnly.Store(false)
+	stateMu.Lock()
+	state = AppState{
+		SchemaVersion: currentSchemaVersion,
+		Users:         map[int64]*User{},
+		Trainers:      defaultTrainers(),
+		Bookings:      []Booking{},
+		SlotsDate:     today(),
+	}
+	stateMu.Unlock()
+	conversationsMu.Lock()
+	clear(conversations)
+	conversationsMu.Unlock()
+}
+
+// textUpdate is a private-chat message from userID, marked as a command
+// when it starts with "/".
+func textUpdate(userID int64, text string) telegram.Update {
+	msg := &telegram.Message{
+		MessageID: 1,
+		From:      &telegram.User{ID: userID, FirstName: "Test", LanguageCode: "ru"},
+		Chat:      &telegram.Chat{ID: userID, Type: "private"},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		cmd, _, _ := strings.Cut(text, " ")
+		msg.Entities = []telegram.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(cmd)}}
+	}
+	return telegram.Update{Message: msg}
+}
+
+// callbackUpdate is a button tap by userID on a message in their chat.
+func callbackUpdate(userID int64, data string) telegram.Update {
+	return telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+		ID:      "cb",
+		From:    &telegram.User{ID: userID, FirstName: "Test", LanguageCode: "ru"},
+		Message: &telegram.Message{MessageID: 1, Chat: &telegram.Chat{ID: userID, Type: "private"}},
+		Data:    data,
+	}}
+}
+
+func TestStartRepliesToTheUser(t *testing.T) {
+	setupState(t)
+	bot := &fakeSender{}
+
+	handleUpdate(bot, textUpdate(42, "/start"))
+
+	texts := bot.texts()
+	if len(texts) == 0 {
+		t.Fatal("/start sent nothing")
+	}
+	for _, c := range bot.sent {
+		if id := chatOf(c); id != 42 {
+			t.Errorf("message sent to chat %d, want 42", id)
+		}
+	}
+	if _, ok := snapshot().Users[42]; !ok {
+		t.Error("/start did not register the user")
+	}
+}
+
+func TestParseStartPayload(t *testing.T) {
+	for _, c := range []struct {
+		payload string
+		id      int
+		ok      bool
+	}{
+		{"trainer_3", 3, true},
+		{" trainer_12 ", 12, true},
+		{"", 0, false},
+		{"trainer_", 0, false},
+		{"trainer_0", 0, false},
+		{"trainer_-1", 0, false},
+		{"trainer_abc", 0, false},
+		{"ref_abc", 0, false},
+		{"3", 0, false},
+	} {
+		id, ok := parseStartPayload(c.payload)
+		if id != c.id || ok != c.ok {
+			t.Errorf("parseStartPayload(%q) = %d, %v; want %d, %v", c.payload, id, ok, c.id, c.ok)
+		}
+	}
+}
+
+func TestStartDeepLinkOpensTrainer(t *testing.T) {
+	setupState(t)
+	bot := &fakeSender{}
+	tr, _ := getTrainerByID(2)
+
+	handleUpdate(bot, textUpdate(42, "/start trainer_2"))
+	if !bot.sentContaining(tr.Name) {
+		t.Errorf("replies %q do not show trainer %q", bot.texts(), tr.Name)
+	}
+
+	bot = &fakeSender{}
+	handleUpdate(bot, textUpdate(43, "/start trainer_99"))
+	if len(bot.texts()) == 0 {
+		t.Error("/start with an unknown trainer sent nothing")
+	}
+	for _, tr := range snapshot().Trainers {
+		if bot.sentContaining(tr.Bio) {
+			t.Errorf("unknown trainer link showed trainer %d", tr.ID)
+		}
+	}
+}
+
+func TestCallbackWithoutMessage(t *testing.T) {
+	setupState(t)
+	for _, data := range []string{"menu", "slot_1_10:00"} {
+		bot := &fakeSender{}
+		u := callbackUpdate(7, data)
+		u.CallbackQuery.Message = nil
+		u.CallbackQuery.InlineMessageID = "inline"
+
+		handleUpdate(bot, u)
+
+		answers := bot.callbackAnswers()
+		if len(answers) != 1 || !answers[0].ShowAlert || !strings.Contains(answers[0].Text, "/start") {
+			t.Errorf("%s: answers = %+v, want one alert pointing to /start", data, answers)
+		}
+		if got := bot.texts(); len(got) != 0 {
+			t.Errorf("%s: sent %q", data, got)
+		}
+	}
+	if !slices.Contains(trainerSlots(t, 1), "10:00") {
+		t.Error("a callback without a message held a slot")
+	}
+}
+
+func TestUpdatesRaceFreeWithSweepers(t *testing.T) {
+	setupState(t)
+	stateMu.Lock()
+	state.Users[7] = &User{ID: 7, Name: "Test", HasPaid: true}
+	stateMu.Unlock()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for range 500 {
+			markBlocked(7)
+			stateMu.Lock()
+			state.Users[7].PaidUntil++
+			stateMu.Unlock()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		bot := &fakeSender{}
+		for range 500 {
+			handleUpdate(bot, callbackUpdate(7, "trainers"))
+			handleUpdate(bot, textUpdate(7, "/find бокс"))
+		}
+	}()
+	wg.Wait()
+}
+
+func TestStartDeepLinkShowsTrainerPhoto(t *testing.T) {
+	setupState(t)
+	if err := updateTrainer(2, func(tr *Trainer) {
+		tr.PhotoURL = "https://example.com/trainer2.jpg"
+		tr.PhotoFileID = "photo-2"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tr, _ := getTrainerByID(2)
+	bot := &fakeSender{}
+
+	handleUpdate(bot, textUpdate(42, "/start trainer_2"))
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	for _, c := range bot.sent {
+		if p, ok := c.(telegram.PhotoConfig); ok && strings.Contains(p.Caption, tr.Name) {
+			if _, ok := p.ReplyMarkup.(telegram.InlineKeyboardMarkup); !ok {
+				t.Error("trainer photo has no keyboard")
+			}
+			return
+		}
+	}
+	t.Errorf("deep link sent no photo card for trainer %q", tr.Name)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ticketTimeout is how long a support ticket waits for a staff reply before
+// it is closed.
+const ticketTimeout = 24 * time.Hour
+
+// Ticket is a support request forwarded to the staff chat, waiting for a
+// staff member to reply to it.
+type Ticket struct {
+	ID       int
+	UserID   int64
+	ChatID   int64
+	OpenedAt time.Time
+}
+
+// tickets maps the ticket's message in the staff chat to the ticket, so a
+// staff reply to that message can be relayed to the user.
+var (
+	tickets      = map[int]Ticket{}
+	nextTicketID int
+	ticketsMu    sync.Mutex
+)
+
+func openTicket(staffMsgID int, t Ticket) {
+	ticketsMu.Lock()
+	defer ticketsMu.Unlock()
+	t.OpenedAt = now()
+	tickets[staffMsgID] = t
+}
+
+// takeTicket closes and returns the ticket for a staff-chat message, if it is
+// still open.
+func takeTicket(staffMsgID int) (Ticket, bool) {
+	ticketsMu.Lock()
+	defer ticketsMu.Unlock()
+	t, ok := tickets[staffMsgID]
+	if !ok {
+		return Ticket{}, false
+	}
+	delete(tickets, staffMsgID)
+	if now().Sub(t.OpenedAt) > ticketTimeout {
+		return Ticket{}, false
+	}
+	return t, true
+}
+
+// pruneTickets closes tickets nobody answered within ticketTimeout.
+func pruneTickets() {
+	ticketsMu.Lock()
+	defer ticketsMu.Unlock()
+	for id, t := range tickets {
+		if now().Sub(t.OpenedAt) > ticketTimeout {
+			delete(tickets, id)
+		}
+	}
+}
+
+// startSupport asks the user for their question.
+func startSupport(bot Sender, chatID, userID int64) {
+	if staffChat == 0 {
+		_ = replyError(bot, chatID, "Поддержка сейчас недоступна. Обратитесь к администратору зала.")
+		return
+	}
+	setConversation(userID, ConversationState{Step: stepSupport})
+	_ = send(bot, telegram.NewMessage(chatID, "Опишите ваш вопрос одним сообщением — мы передадим его администратору, ответ придёт сюда."))
+}
+
+// handleSupportMessage forwards the user's question to the staff chat as a
+// new ticket.
+func handleSupportMessage(bot Sender, msg *telegram.Message, name string) {
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		setConversation(msg.From.ID, ConversationState{Step: stepSupport})
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Пожалуйста, отправьте вопрос текстом."))
+		return
+	}
+	ticketsMu.Lock()
+	nextTicketID++
+	id := nextTicketID
+	ticketsMu.Unlock()
+
+	fwd := fmt.Sprintf("<b>🆘 Обращение #%d</b>\nОт: %s (id %d)\n\n%s\n\n<i>Ответьте на это сообщение, чтобы ответить пользователю.</i>",
+		id, escapeHTML(name), msg.From.ID, escapeHTML(text))
+	sent, err := sendSync(bot, htmlMessage(staffChat, fwd))
+	if err != nil {
+		_ = replyError(bot, msg.Chat.ID, "Не удалось отправить обращение, попробуйте позже.")
+		return
+	}
+	openTicket(sent.MessageID, Ticket{ID: id, UserID: msg.From.ID, ChatID: msg.Chat.ID})
+	reply := telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Обращение #%d передано в поддержку. Ответ придёт в этот чат.", id))
+	reply.ReplyMarkup = mainMenuKeyboard(userLang(msg.From.ID))
+	_ = send(bot, reply)
+}
+
+// relayTicketReply sends a staff reply to a ticket back to the user and
+// closes the ticket. It reports whether msg was such a reply.
+func relayTicketReply(bot Sender, msg *telegram.Message) bool {
+	if staffChat == 0 || msg.Chat.ID != staffChat || msg.ReplyToMessage == nil {
+		return false
+	}
+	t, ok := takeTicket(msg.ReplyToMessage.MessageID)
+	if !ok {
+		return false
+	}
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		openTicket(msg.ReplyToMessage.MessageID, t)
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, "Ответ должен быть текстом."))
+		return true
+	}
+	if _, err := sendSync(bot, telegram.NewMessage(t.ChatID, fmt.Sprintf("💬 Ответ поддержки на обращение #%d:\n\n%s", t.ID, text))); err != nil {
+		openTicket(msg.ReplyToMessage.MessageID, t)
+		_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Не удалось доставить ответ на обращение #%d: %v", t.ID, err)))
+		return true
+	}
+	_ = send(bot, telegram.NewMessage(msg.Chat.ID, fmt.Sprintf("Ответ на обращение #%d отправлен, обращение закрыто.", t.ID)))
+	return true
+}
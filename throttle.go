@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxSendRate caps SEND_RATE at Telegram's documented bot-wide limit.
+const maxSendRate = 30
+
+var (
+	// sendRate is how many messages per second the bot sends in total.
+	sendRate = 25
+	// chatSendInterval is the minimum gap between proactive messages to one
+	// chat.
+	chatSendInterval = time.Second
+)
+
+// sendTokens holds one token per message allowed right now. It is nil
+// until startThrottle is called; until then sends are not limited.
+var sendTokens chan struct{}
+
+var (
+	chatSentMu   sync.Mutex
+	chatLastSent = map[int64]time.Time{}
+)
+
+func loadThrottleConfig() {
+	if v := os.Getenv("SEND_RATE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSendRate {
+			configProblem("SEND_RATE: expected 1..%d messages per second, got %q", maxSendRate, v)
+		} else {
+			sendRate = n
+		}
+	}
+	if v := os.Getenv("CHAT_SEND_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			configProblem("CHAT_SEND_INTERVAL: invalid duration %q", v)
+		} else {
+			chatSendInterval = d
+		}
+	}
+}
+
+// startThrottle refills sendTokens at rate per second. The bucket holds at
+// most one second's worth, so an idle bot can't save up a burst above the
+// limit.
+func startThrottle(rate int) {
+	sendTokens = make(chan struct{}, rate)
+	go func() {
+		for range time.Tick(time.Second / time.Duration(rate)) {
+			select {
+			case sendTokens <- struct{}{}:
+			default:
+			}
+		}
+	}()
+}
+
+// waitGlobal blocks until the bot-wide limit allows another message.
+func waitGlobal() {
+	if sendTokens != nil {
+		<-sendTokens
+	}
+}
+
+// waitChat blocks until chatSendInterval has passed since the last paced
+// message to chatID. A chat's jobs all run on one worker, so two waits for
+// the same chat never overlap.
+func waitChat(chatID int64) {
+	chatSentMu.Lock()
+	next := chatLastSent[chatID].Add(chatSendInterval)
+	chatSentMu.Unlock()
+	if d := time.Until(next); d > 0 {
+		time.Sleep(d)
+	}
+	chatSentMu.Lock()
+	chatLastSent[chatID] = time.Now()
+	chatSentMu.Unlock()
+}
+
+// pruneChatPacing forgets chats that haven't had a paced message for longer
+// than the interval; they can be sent to immediately anyway.
+func pruneChatPacing() {
+	cutoff := time.Now().Add(-chatSendInterval)
+	chatSentMu.Lock()
+	defer chatSentMu.Unlock()
+	for id, t := range chatLastSent {
+		if t.Before(cutoff) {
+			delete(chatLastSent, id)
+		}
+	}
+}
+
+// notify is send for messages the bot sends on its own (reminders, alerts,
+// broadcasts) rather than in reply to the user. They are paced per chat on
+// top of the bot-wide limit, so a burst of notifications queues up instead
+// of running into Telegram's 429s.
+func notify(bot Sender, msg telegram.Chattable) error {
+	return dispatch(outJob{bot: bot, chatID: chatOf(msg), msgs: []telegram.Chattable{msg}, paced: true}, false).err
+}
+
+// notifySync is notify for callers that need the delivery result.
+func notifySync(bot Sender, msg telegram.Chattable) (telegram.Message, error) {
+	res := dispatch(outJob{bot: bot, chatID: chatOf(msg), msgs: []telegram.Chattable{msg}, paced: true}, true)
+	return res.msg, res.err
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	telegram "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func trainerIDs(trainers []Trainer) []int {
+	ids := make([]int, len(trainers))
+	for i, t := range trainers {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func TestSearchTrainers(t *testing.T) {
+	setupState(t)
+	for _, c := range []struct {
+		query string
+		want  []int
+	}{
+		{"бокс", []int{3}},
+		{"  ЙОГА ", []int{4}},
+		{"пауэрлифтинг", []int{1}},
+		{"Алия", []int{2}},
+		{"плавание", nil},
+	} {
+		if got := trainerIDs(searchTrainers(c.query)); len(got)+len(c.want) > 0 && !slices.Equal(got, c.want) {
+			t.Errorf("searchTrainers(%q) = %v, want %v", c.query, got, c.want)
+		}
+	}
+
+	if err := updateTrainer(5, func(tr *Trainer) { tr.Specialties = []string{"TRX"} }); err != nil {
+		t.Fatal(err)
+	}
+	if got := trainerIDs(searchTrainers("trx")); !slices.Equal(got, []int{5}) {
+		t.Errorf("search by specialty = %v, want [5]", got)
+	}
+
+	if err := updateTrainer(3, func(tr *Trainer) { tr.Active = false }); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchTrainers("бокс"); len(got) != 0 {
+		t.Errorf("search found deleted trainer %v", trainerIDs(got))
+	}
+}
+
+// buttonData lists the callback data of every button in kb, row by row.
+func buttonData(kb telegram.InlineKeyboardMarkup) []string {
+	var res []string
+	for _, row := range kb.InlineKeyboard {
+		for _, b := range row {
+			if b.CallbackData != nil {
+				res = append(res, *b.CallbackData)
+			}
+		}
+	}
+	return res
+}
+
+func TestGroupClassFilter(t *testing.T) {
+	setupState(t)
+	defer func(n int) { trainersPerPage = n }(trainersPerPage)
+	trainersPerPage = 50
+
+	if data := buttonData(trainersPageKeyboard(true, 0, filterAll)); slices.Contains(data, "trainerspage_0_group") {
+		t.Error("filter row shown without any group classes")
+	}
+
+	if err := updateTrainer(4, func(tr *Trainer) { tr.GroupClass = true }); err != nil {
+		t.Fatal(err)
+	}
+	kb := trainersPageKeyboard(true, 0, filterGroup)
+	filters := kb.InlineKeyboard[0]
+	if len(filters) != 3 || filters[1].Text != "• 👥 Групповые" {
+		t.Errorf("filter row = %+v, want three buttons with group selected", filters)
+	}
+	data := buttonData(kb)
+	if !slices.Contains(data, "trainer_4") || slices.Contains(data, "trainer_1") {
+		t.Errorf("group filter buttons = %v, want only trainer 4", data)
+	}
+	if row := kb.InlineKeyboard[1]; row[0].Text != "👥 "+snapshot().Trainers[3].Name {
+		t.Errorf("group trainer label = %q", row[0].Text)
+	}
+
+	data = buttonData(trainersPageKeyboard(true, 0, filterPersonal))
+	if slices.Contains(data, "trainer_4") || !slices.Contains(data, "trainer_1") {
+		t.Errorf("personal filter buttons = %v, want everyone but trainer 4", data)
+	}
+	data = buttonData(trainersPageKeyboard(true, 0, filterAll))
+	if !slices.Contains(data, "trainer_4") || !slices.Contains(data, "trainer_1") {
+		t.Errorf("unfiltered buttons = %v, want everyone", data)
+	}
+
+	if err := updateTrainer(4, func(tr *Trainer) { tr.GroupClass = false }); err != nil {
+		t.Fatal(err)
+	}
+	if data := buttonData(trainersPageKeyboard(true, 0, filterGroup)); !slices.Contains(data, "noop") {
+		t.Errorf("empty group filter buttons = %v, want the empty notice", data)
+	}
+}
+
+func TestTrainerDetailsText(t *testing.T) {
+	setupState(t)
+	tr, _ := getTrainerByID(3)
+
+	text := trainerDetailsMessage(1, *tr, true).Text
+	if strings.Contains(text, "Специализация") {
+		t.Errorf("specialty line shown without specialties:\n%s", text)
+	}
+	if !strings.HasPrefix(text, tr.Name+"\n\nОписание: "+tr.Bio) {
+		t.Errorf("details do not open with name and bio:\n%s", text)
+	}
+
+	tr.Specialties = []string{"бокс", "ОФП"}
+	tr.PriceModifier = 2000
+	text = trainerDetailsMessage(1, *tr, true).Text
+	if !strings.HasPrefix(text, tr.Name+"\nСпециализация: бокс, ОФП\n\nОписание: ") {
+		t.Errorf("specialties not right under the name:\n%s", text)
+	}
+	if !strings.Contains(text, "Доплата: +"+formatMoney(2000)) {
+		t.Errorf("surcharge missing:\n%s", text)
+	}
+
+	tr.Away = true
+	if text := trainerDetailsMessage(1, *tr, true).Text; !strings.HasSuffix(text, "⛔ Временно недоступен.") {
+		t.Errorf("away trainer:\n%s", text)
+	}
+	tr.Active = false
+	if text := trainerDetailsMessage(1, *tr, true).Text; !strings.HasSuffix(text, "Тренер больше не принимает записи.") {
+		t.Errorf("deleted trainer:\n%s", text)
+	}
+}
+
+func TestParseSpecialties(t *testing.T) {
+	tags, err := parseSpecialties(" бокс, ОФП ,, Бокс ,кроссфит")
+	if err != nil || !slices.Equal(tags, []string{"бокс", "ОФП", "кроссфит"}) {
+		t.Errorf("got %q, %v", tags, err)
+	}
+	if tags, err := parseSpecialties("off"); err != nil || tags != nil {
+		t.Errorf("off: got %q, %v; want none", tags, err)
+	}
+	if _, err := parseSpecialties(strings.Repeat("я", maxSpecialtyLen+1)); err == nil {
+		t.Error("overlong specialty accepted")
+	}
+	many := make([]string, maxSpecialties+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("tag%d", i)
+	}
+	if _, err := parseSpecialties(strings.Join(many, ",")); err == nil {
+		t.Errorf("%d specialties accepted", len(many))
+	}
+}